@@ -9,9 +9,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gocelery/gocelery"
 	"github.com/gorilla/websocket"
+	"github.com/inwinstack/kaoliang/pkg/caches"
 	"github.com/inwinstack/kaoliang/pkg/config"
+	"github.com/inwinstack/kaoliang/pkg/controllers"
 	"github.com/inwinstack/kaoliang/pkg/models"
 	"github.com/inwinstack/kaoliang/pkg/utils"
 	"github.com/joho/godotenv"
@@ -32,6 +33,7 @@ type Metadata struct {
 type Source struct {
 	Bucket   string   `json:"bucket"`
 	Object   string   `json:"name"`
+	Instance string   `json:"instance"`
 	Metadata Metadata `json:"meta"`
 	Owner    Owner    `json:"owner"`
 }
@@ -52,7 +54,8 @@ func init() {
 	models.SetDB()
 	models.Migrate()
 	models.SetCache()
-	models.SetCelery()
+	caches.SetRedis()
+	models.SetSigningKey()
 }
 
 func main() {
@@ -106,7 +109,6 @@ func main() {
 func sendEvent(change Change, eventType event.Name) error {
 	bucketName := change.Source.Bucket
 	objectName := change.Source.Object
-	client := models.GetCache()
 	serverConfig := config.GetServerConfig()
 	nConfig := models.Config{}
 	db := models.GetDB()
@@ -118,7 +120,15 @@ func sendEvent(change Change, eventType event.Name) error {
 	rulesMap := nConfig.ToRulesMap()
 	eventTime := time.Now().UTC()
 
-	for _, resource := range rulesMap[eventType].Match(objectName) {
+	targets := rulesMap[eventType].Match(objectName)
+	if len(targets) == 0 {
+		return nil
+	}
+
+	ownerID, _ := controllers.GetBucketOwner(bucketName)
+
+	for _, target := range targets {
+		resource := target.Resource
 		newEvent := event.Event{
 			EventVersion: "2.0",
 			EventSource:  "aws:s3",
@@ -126,7 +136,7 @@ func sendEvent(change Change, eventType event.Name) error {
 			EventTime:    eventTime.Format("2006-01-02T15:04:05Z"),
 			EventName:    eventType,
 			UserIdentity: event.Identity{
-				PrincipalID: "",
+				PrincipalID: principalID(change.Source.Owner),
 			},
 			RequestParameters: map[string]string{
 				"sourceIPAddress": "",
@@ -136,11 +146,11 @@ func sendEvent(change Change, eventType event.Name) error {
 			},
 			S3: event.Metadata{
 				SchemaVersion:   "1.0",
-				ConfigurationID: "Config",
+				ConfigurationID: target.ConfigurationID,
 				Bucket: event.Bucket{
 					Name: bucketName,
 					OwnerIdentity: event.Identity{
-						PrincipalID: change.Source.Owner.DisplayName,
+						PrincipalID: ownerID,
 					},
 					ARN: resource.ARN(),
 				},
@@ -148,6 +158,7 @@ func sendEvent(change Change, eventType event.Name) error {
 					Key:       objectName,
 					Size:      change.Source.Metadata.Size,
 					ETag:      change.Source.Metadata.Etag,
+					VersionID: change.Source.Instance,
 					Sequencer: fmt.Sprintf("%X", eventTime.UnixNano()),
 				},
 			},
@@ -157,16 +168,21 @@ func sendEvent(change Change, eventType event.Name) error {
 
 		switch resource.Service {
 		case models.SQS:
-			client.RPush(fmt.Sprintf("%s:%s:%s", resource.Service.String(), resource.AccountID, resource.Name), value)
+			resource.SendMessages(resource.NewEventMessage(string(value), bucketName, objectName, eventType.String()))
 		case models.SNS:
-			celeryBroker, celeryBackend := models.GetCelery()
-			celeryClient, _ := gocelery.NewCeleryClient(celeryBroker, celeryBackend, 0)
-
-			for _, endpoint := range resource.Endpoints {
-				celeryClient.Delay("worker.send_event", endpoint.URI, string(value))
-			}
+			resource.Publish(resource.NewEventNotification(string(value), bucketName, objectName, eventType.String(), change.Source.Metadata.Size))
 		}
 	}
 
 	return nil
 }
+
+// principalID - returns the user who wrote the object without the subuser,
+// like the principal of events sent for requests to the proxy.
+func principalID(owner Owner) string {
+	if index := strings.LastIndex(owner.ID, ":"); index != -1 {
+		return owner.ID[:index]
+	}
+
+	return owner.ID
+}
@@ -42,7 +42,7 @@ func init() {
 	models.SetDB()
 	models.Migrate()
 	models.SetCache()
-	models.SetCelery()
+	models.SetSigningKey()
 	caches.SetRedis()
 }
 
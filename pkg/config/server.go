@@ -16,6 +16,8 @@ package config
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/minio/minio/cmd"
 
@@ -33,9 +35,45 @@ type ServerConfig struct {
 	EnableKaoliangCopy   string
 	EnableKaoliangDelete string
 	EnableElasticCreate  string
+
+	// Limits of the server applied to every queue of every account, they
+	// are not queue attributes, see SetServerConfig
+	QueueMaxDepth       int
+	QueueOverflowPolicy string
+
+	// Settings of the delivery worker
+	DeliveryWorkers            int
+	DeliveryConcurrency        int
+	DeliveryTimeout            time.Duration
+	DeliveryCAFile             string
+	DeliveryInsecureSkipVerify bool
+	DeliveryAttemptRetention   time.Duration
+
+	// Settings of SNS message signatures
+	SigningKeyFile  string
+	SigningCertFile string
 }
 
 func SetServerConfig() {
+	// Queues are unlimited unless SQS_MAX_QUEUE_DEPTH is set, messages sent
+	// to a full queue are rejected or replace the oldest messages according
+	// to SQS_OVERFLOW_POLICY, either reject or drop-oldest. Both are settings
+	// of the whole server: every queue of every account has the same limit,
+	// and they cannot be set or read as attributes of queues. Dropped
+	// messages are counted by ApproximateNumberOfMessagesDropped.
+	queueMaxDepth, _ := strconv.Atoi(utils.GetEnv("SQS_MAX_QUEUE_DEPTH", "0"))
+
+	// The delivery worker delivers up to DELIVERY_WORKERS messages at the same
+	// time, and up to DELIVERY_ENDPOINT_CONCURRENCY of them to each endpoint.
+	deliveryWorkers, _ := strconv.Atoi(utils.GetEnv("DELIVERY_WORKERS", "64"))
+	deliveryConcurrency, _ := strconv.Atoi(utils.GetEnv("DELIVERY_ENDPOINT_CONCURRENCY", "4"))
+	deliveryTimeout, _ := strconv.Atoi(utils.GetEnv("DELIVERY_TIMEOUT", "15"))
+	deliveryInsecureSkipVerify, _ := strconv.ParseBool(utils.GetEnv("DELIVERY_INSECURE_SKIP_VERIFY", "false"))
+
+	// Attempts of deliveries are recorded and kept for
+	// DELIVERY_ATTEMPT_RETENTION_DAYS, or forever when it is zero.
+	deliveryAttemptRetention, _ := strconv.Atoi(utils.GetEnv("DELIVERY_ATTEMPT_RETENTION_DAYS", "7"))
+
 	serverConfig = &ServerConfig{
 		Region:               utils.GetEnv("RGW_REGION", "us-east-1"),
 		Host:                 utils.GetEnv("RGW_DNS_NAME", "cloud.inwinstack.com"),
@@ -45,6 +83,21 @@ func SetServerConfig() {
 		EnableKaoliangCopy:   utils.GetEnv("ENABLE_KAOLIANG_COPY", "True"),
 		EnableKaoliangDelete: utils.GetEnv("ENABLE_KAOLIANG_DELETE", "True"),
 		EnableElasticCreate:  utils.GetEnv("ENABLE_ELASTIC_CREATE", "True"),
+		QueueMaxDepth:        queueMaxDepth,
+		QueueOverflowPolicy:  utils.GetEnv("SQS_OVERFLOW_POLICY", "reject"),
+
+		DeliveryWorkers:            deliveryWorkers,
+		DeliveryConcurrency:        deliveryConcurrency,
+		DeliveryTimeout:            time.Duration(deliveryTimeout) * time.Second,
+		DeliveryCAFile:             utils.GetEnv("DELIVERY_CA_FILE", ""),
+		DeliveryInsecureSkipVerify: deliveryInsecureSkipVerify,
+		DeliveryAttemptRetention:   time.Duration(deliveryAttemptRetention) * 24 * time.Hour,
+
+		// Messages to endpoints are signed with the RSA private key in
+		// SNS_SIGNING_KEY_FILE, and the sns service serves the certificate
+		// in SNS_SIGNING_CERT_FILE for receivers to verify them.
+		SigningKeyFile:  utils.GetEnv("SNS_SIGNING_KEY_FILE", ""),
+		SigningCertFile: utils.GetEnv("SNS_SIGNING_CERT_FILE", ""),
 	}
 }
 
@@ -24,17 +24,21 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	sh "github.com/codeskyblue/go-sh"
 	"github.com/gin-gonic/gin"
 	"github.com/go-sql-driver/mysql"
-	"github.com/gocelery/gocelery"
+	"github.com/inwinstack/kaoliang/pkg/caches"
 	"github.com/inwinstack/kaoliang/pkg/config"
 	"github.com/inwinstack/kaoliang/pkg/models"
 	"github.com/inwinstack/kaoliang/pkg/utils"
 	"github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
 	"github.com/minio/minio/pkg/event"
 )
 
@@ -107,6 +111,7 @@ func PutBucketNotification(c *gin.Context) {
 		writeErrorResponse(c, cmd.ErrAccessDenied)
 		return
 	}
+	defer models.ResetObjectAccessedRules()
 
 	xmlConfig := models.Config{}
 	data, _ := ioutil.ReadAll(c.Request.Body)
@@ -248,11 +253,69 @@ func getObjectName(req *http.Request) (bucketName string, objectName string, err
 	return
 }
 
+// objectEvent - an event of the object, which is sent to the queues and
+// topics of the notification rules matching the event and the object.
+type objectEvent struct {
+	name   event.Name
+	object event.Object
+
+	// stat - whether the size and ETag of the object are looked up by a HEAD
+	// request, which is done once the event matches any rules.
+	stat bool
+
+	// accessKey - the access key of uploads authenticated by their form
+	// rather than the Authorization header.
+	accessKey string
+}
+
 func sendEvent(resp *http.Response, eventType event.Name) error {
 	clientReq := resp.Request
-	bucketName, objectName, _ := getObjectName(clientReq)
+	_, objectName, _ := getObjectName(clientReq)
+
+	// Bodies of streaming signed uploads hold the signatures of chunks
+	size := clientReq.ContentLength
+	if decoded, err := strconv.ParseInt(clientReq.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+		size = decoded
+	}
+
+	// Bodies of copies and multipart completes are not the object, and the
+	// size of chunked uploads is unknown
+	stat := eventType == event.ObjectCreatedCopy || eventType == event.ObjectCreatedCompleteMultipartUpload ||
+		eventType == event.ObjectCreatedPut && size < 0
+	if stat || size < 0 {
+		size = 0
+	}
+
+	return sendEvents(resp, []objectEvent{{
+		name: eventType,
+		object: event.Object{
+			Key:       objectName,
+			Size:      size,
+			ETag:      resp.Header.Get("Etag"),
+			VersionID: resp.Header.Get("X-Amz-Version-Id"),
+		},
+		stat: stat,
+	}})
+}
+
+// deleteEventName - returns the event of the deleted object, which is
+// ObjectRemoved:DeleteMarkerCreated when the delete of an object in a
+// versioned bucket created a delete marker. Like in multi-object deletes,
+// removing a delete marker by its version is ObjectRemoved:Delete.
+func deleteEventName(resp *http.Response) event.Name {
+	_, byVersion := resp.Request.URL.Query()["versionId"]
+	if resp.Header.Get("X-Amz-Delete-Marker") == "true" && !byVersion {
+		return models.ObjectRemovedDeleteMarkerCreated
+	}
+
+	return event.ObjectRemovedDelete
+}
+
+// sendEvents - sends the events of objects in the bucket of the request.
+func sendEvents(resp *http.Response, objectEvents []objectEvent) error {
+	clientReq := resp.Request
+	bucketName, _, _ := getObjectName(clientReq)
 
-	client := models.GetCache()
 	serverConfig := config.GetServerConfig()
 	nConfig := models.Config{}
 	db := models.GetDB()
@@ -264,65 +327,327 @@ func sendEvent(resp *http.Response, eventType event.Name) error {
 	rulesMap := nConfig.ToRulesMap()
 	eventTime := time.Now().UTC()
 
-	var etag string
-	if val, ok := resp.Header["Etag"]; ok {
-		etag = val[0]
-	}
-
-	for _, resource := range rulesMap[eventType].Match(objectName) {
-		newEvent := event.Event{
-			EventVersion: "2.0",
-			EventSource:  "aws:s3",
-			AwsRegion:    serverConfig.Region,
-			EventTime:    eventTime.Format("2006-01-02T15:04:05Z"),
-			EventName:    eventType,
-			UserIdentity: event.Identity{
-				PrincipalID: "",
-			},
-			RequestParameters: map[string]string{
-				"sourceIPAddress": clientReq.RemoteAddr,
-			},
-			ResponseElements: map[string]string{
-				"x-amz-request-id": resp.Header["X-Amz-Request-Id"][0],
-			},
-			S3: event.Metadata{
-				SchemaVersion:   "1.0",
-				ConfigurationID: "Config",
-				Bucket: event.Bucket{
-					Name: bucketName,
-					OwnerIdentity: event.Identity{
-						PrincipalID: "",
-					},
-					ARN: resource.ARN(),
+	// Users are looked up in RGW once any events match the rules
+	users := map[string]requestUser{}
+	var ownerID string
+	var ownerFound bool
+
+	for _, objectEvent := range objectEvents {
+		eventType := objectEvent.name
+		object := objectEvent.object
+		object.Sequencer = fmt.Sprintf("%X", eventTime.UnixNano())
+
+		targets := rulesMap[eventType].Match(object.Key)
+		if len(targets) == 0 {
+			continue
+		}
+
+		accessKey := objectEvent.accessKey
+		if accessKey == "" {
+			accessKey = ExtractAccessKey(clientReq)
+		}
+		user, ok := users[accessKey]
+		if !ok {
+			user = getRequestUser(accessKey)
+			users[accessKey] = user
+		}
+		if !ownerFound {
+			ownerID, _ = GetBucketOwner(bucketName)
+			ownerFound = true
+		}
+		if objectEvent.stat {
+			statObject(user, bucketName, &object)
+		}
+
+		for _, target := range targets {
+			resource := target.Resource
+			newEvent := event.Event{
+				EventVersion: "2.0",
+				EventSource:  "aws:s3",
+				AwsRegion:    serverConfig.Region,
+				EventTime:    eventTime.Format("2006-01-02T15:04:05Z"),
+				EventName:    eventType,
+				UserIdentity: event.Identity{
+					PrincipalID: user.principalID,
 				},
-				Object: event.Object{
-					Key:       objectName,
-					Size:      clientReq.ContentLength,
-					ETag:      etag,
-					Sequencer: fmt.Sprintf("%X", eventTime.UnixNano()),
+				RequestParameters: map[string]string{
+					"sourceIPAddress": clientReq.RemoteAddr,
 				},
-			},
+				ResponseElements: map[string]string{
+					"x-amz-request-id": resp.Header.Get("X-Amz-Request-Id"),
+					"x-amz-id-2":       resp.Header.Get("X-Amz-Id-2"),
+				},
+				S3: event.Metadata{
+					SchemaVersion:   "1.0",
+					ConfigurationID: target.ConfigurationID,
+					Bucket: event.Bucket{
+						Name: bucketName,
+						OwnerIdentity: event.Identity{
+							PrincipalID: ownerID,
+						},
+						ARN: resource.ARN(),
+					},
+					Object: object,
+				},
+			}
+
+			value, err := models.MarshalEvent(newEvent)
+			if err != nil {
+				panic(err)
+			}
+
+			eventName := models.EventNameString(eventType)
+			switch resource.Service {
+			case models.SQS:
+				resource.SendMessages(resource.NewEventMessage(string(value), bucketName, object.Key, eventName))
+			case models.SNS:
+				resource.Publish(resource.NewEventNotification(string(value), bucketName, object.Key, eventName, object.Size))
+			}
+		}
+	}
+
+	return nil
+}
+
+// requestUser - the user authenticated by the access key of the request.
+type requestUser struct {
+	principalID string
+	creds       auth.Credentials
+}
+
+// getRequestUser - looks up the user of the access key, the principal is the
+// user without the subuser.
+func getRequestUser(accessKey string) requestUser {
+	if accessKey == "" {
+		return requestUser{}
+	}
+
+	name, creds, errCode := cmd.GetCredentials(accessKey)
+	if errCode != cmd.ErrNone || name == "" {
+		return requestUser{}
+	}
+
+	if index := strings.LastIndex(name, ":"); index != -1 {
+		name = name[:index]
+	}
+
+	return requestUser{principalID: name, creds: creds}
+}
+
+// statObject - sets the size and ETag of the object by a HEAD request with
+// the credentials of the user, objects of anonymous requests are not looked
+// up.
+func statObject(user requestUser, bucketName string, object *event.Object) {
+	if user.creds.AccessKey == "" {
+		return
+	}
+
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(object.Key),
+	}
+	if object.VersionID != "" {
+		input.VersionId = aws.String(object.VersionID)
+	}
+
+	output, err := newS3Client(user.creds).HeadObject(input)
+	if err != nil {
+		return
+	}
+
+	object.Size = aws.Int64Value(output.ContentLength)
+	if etag := aws.StringValue(output.ETag); etag != "" {
+		object.ETag = etag
+	}
+}
+
+// subresources - query parameters of requests to subresources of objects,
+// which are not accesses of the objects.
+var subresources = []string{"acl", "attributes", "legal-hold", "retention", "tagging", "torrent", "uploadId"}
+
+// sendAccessEvent - sends the event of the object read by the GET or HEAD
+// request. Reads of buckets without ObjectAccessed rules skip looking up the
+// rules of the bucket.
+func sendAccessEvent(resp *http.Response, eventType event.Name) error {
+	clientReq := resp.Request
+	bucketName, objectName, _ := getObjectName(clientReq)
+	if objectName == "" || !models.HasObjectAccessedRules(bucketName) {
+		return nil
+	}
+
+	query := clientReq.URL.Query()
+	for _, subresource := range subresources {
+		if _, ok := query[subresource]; ok {
+			return nil
 		}
+	}
+
+	return sendEvents(resp, []objectEvent{{
+		name: eventType,
+		object: event.Object{
+			Key:       objectName,
+			Size:      objectSize(resp),
+			ETag:      resp.Header.Get("Etag"),
+			VersionID: resp.Header.Get("X-Amz-Version-Id"),
+		},
+	}})
+}
+
+// objectSize - returns the size of the object read by the response, which
+// is the complete length of partial responses.
+func objectSize(resp *http.Response) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		contentRange := resp.Header.Get("Content-Range")
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			if size, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				return size
+			}
+		}
+		return 0
+	}
+
+	if resp.ContentLength < 0 {
+		return 0
+	}
+
+	return resp.ContentLength
+}
+
+// deletedObject - an object of a multi-object delete, by its key and version.
+type deletedObject struct {
+	Key       string
+	VersionID string `xml:"VersionId"`
+}
+
+// deleteRequest - the objects requested by a multi-object delete.
+type deleteRequest struct {
+	Objects []deletedObject `xml:"Object"`
+}
+
+// deleteResult - the result of a multi-object delete.
+type deleteResult struct {
+	Deleted []struct {
+		Key                   string
+		VersionID             string `xml:"VersionId"`
+		DeleteMarker          bool
+		DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId"`
+	}
+	Errors []deletedObject `xml:"Error"`
+}
+
+// isMultiObjectDelete - reports whether the request deletes multiple objects.
+func isMultiObjectDelete(request *http.Request) bool {
+	_, ok := request.URL.Query()["delete"]
+	return ok
+}
+
+// readDeleteRequest - reads the body of the multi-object delete, and puts it
+// back for the backend.
+func readDeleteRequest(req *http.Request) []byte {
+	if req.Body == nil {
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return nil
+	}
+
+	return b
+}
+
+// sendDeleteEvents - sends an event for each object deleted by the
+// multi-object delete. Deletes creating delete markers send
+// ObjectRemoved:DeleteMarkerCreated, other deletes, including ones removing
+// delete markers by their versions, send ObjectRemoved:Delete. Quiet deletes
+// only return errors, so the requested objects without errors are deleted,
+// and the ones without versions in versioned buckets created delete markers.
+func sendDeleteEvents(resp *http.Response, requestBody []byte) error {
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b)) // put body back for client response
+	if err != nil {
+		return err
+	}
+
+	result := deleteResult{}
+	if err := xml.Unmarshal(b, &result); err != nil {
+		return nil
+	}
+
+	request := deleteRequest{}
+	xml.Unmarshal(requestBody, &request)
+
+	reported := map[deletedObject]bool{}
+	for _, failed := range result.Errors {
+		reported[failed] = true
+	}
 
-		value, err := json.Marshal(newEvent)
-		if err != nil {
-			panic(err)
+	objectEvents := []objectEvent{}
+	for _, deleted := range result.Deleted {
+		reported[deletedObject{deleted.Key, deleted.VersionID}] = true
+		if deleted.DeleteMarker && deleted.VersionID == "" {
+			objectEvents = append(objectEvents, objectEvent{
+				name:   models.ObjectRemovedDeleteMarkerCreated,
+				object: event.Object{Key: deleted.Key, VersionID: deleted.DeleteMarkerVersionID},
+			})
+			continue
 		}
 
-		switch resource.Service {
-		case models.SQS:
-			client.RPush(fmt.Sprintf("%s:%s:%s", resource.Service.String(), resource.AccountID, resource.Name), value)
-		case models.SNS:
-			celeryBroker, celeryBackend := models.GetCelery()
-			celeryClient, _ := gocelery.NewCeleryClient(celeryBroker, celeryBackend, 0)
+		objectEvents = append(objectEvents, objectEvent{
+			name:   event.ObjectRemovedDelete,
+			object: event.Object{Key: deleted.Key, VersionID: deleted.VersionID},
+		})
+	}
+
+	var versioned, versioningFound bool
+	for _, object := range request.Objects {
+		if reported[object] {
+			continue
+		}
+		reported[object] = true
 
-			for _, endpoint := range resource.Endpoints {
-				celeryClient.Delay("worker.send_event", endpoint.URI, string(value))
+		name := event.ObjectRemovedDelete
+		if object.VersionID == "" {
+			if !versioningFound {
+				versioned = isVersionedBucket(resp.Request)
+				versioningFound = true
+			}
+			if versioned {
+				name = models.ObjectRemovedDeleteMarkerCreated
 			}
 		}
+
+		objectEvents = append(objectEvents, objectEvent{
+			name:   name,
+			object: event.Object{Key: object.Key, VersionID: object.VersionID},
+		})
 	}
 
-	return nil
+	return sendEvents(resp, objectEvents)
+}
+
+// isVersionedBucket - reports whether versioning of the bucket of the request
+// is enabled or suspended, in which case deletes without versions create
+// delete markers. Buckets of anonymous requests are not looked up.
+func isVersionedBucket(req *http.Request) bool {
+	user := getRequestUser(ExtractAccessKey(req))
+	if user.creds.AccessKey == "" {
+		return false
+	}
+
+	bucketName, _, _ := getObjectName(req)
+	output, err := newS3Client(user.creds).GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return false
+	}
+
+	status := aws.StringValue(output.Status)
+	return status == s3.BucketVersioningStatusEnabled || status == s3.BucketVersioningStatusSuspended
 }
 
 func isMultipartUpload(request *http.Request) bool {
@@ -338,9 +663,18 @@ func ReverseProxy() gin.HandlerFunc {
 	target := utils.GetEnv("TARGET_HOST", "127.0.0.1")
 
 	return func(c *gin.Context) {
+		var upload *postUpload
+		var deleteRequestBody []byte
 		director := func(req *http.Request) {
 			req.URL.Scheme = "http"
 			req.URL.Host = target
+			cfg := config.GetServerConfig()
+			if isPostUpload(req) && cfg.EnableKaoliangCreate == "True" {
+				upload = watchPostUpload(req)
+			}
+			if req.Method == "POST" && isMultiObjectDelete(req) && cfg.EnableKaoliangDelete == "True" {
+				deleteRequestBody = readDeleteRequest(req)
+			}
 		}
 
 		modifyResponse := func(resp *http.Response) error {
@@ -357,12 +691,20 @@ func ReverseProxy() gin.HandlerFunc {
 				return nil
 			case len(clientReq.Header["X-Amz-Copy-Source"]) > 0 && cfg.EnableKaoliangCopy == "True":
 				return sendEvent(resp, event.ObjectCreatedCopy)
+			case checkResponse(resp, "POST", 200) && isMultiObjectDelete(clientReq) && cfg.EnableKaoliangDelete == "True":
+				return sendDeleteEvents(resp, deleteRequestBody)
+			case upload != nil && isPostUploadSuccess(resp):
+				return sendPostEvent(resp, upload)
 			case checkResponse(resp, "POST", 200) && len(clientReq.URL.Query()["uploadId"]) != 0:
 				return sendEvent(resp, event.ObjectCreatedCompleteMultipartUpload)
 			case len(resp.Header["Etag"]) > 0 && checkResponse(resp, "PUT", 200) && !isMultipartUpload(clientReq) && cfg.EnableKaoliangCreate == "True":
 				return sendEvent(resp, event.ObjectCreatedPut)
 			case checkResponse(resp, "DELETE", 204) && cfg.EnableKaoliangDelete == "True":
-				return sendEvent(resp, event.ObjectRemovedDelete)
+				return sendEvent(resp, deleteEventName(resp))
+			case checkResponse(resp, "GET", 200) || checkResponse(resp, "GET", 206):
+				return sendAccessEvent(resp, event.ObjectAccessedGet)
+			case checkResponse(resp, "HEAD", 200):
+				return sendAccessEvent(resp, event.ObjectAccessedHead)
 			default:
 				return nil
 			}
@@ -378,15 +720,23 @@ type Grant struct {
 }
 
 type Policy struct {
-	ACL ACL `json:"acl"`
+	ACL   ACL   `json:"acl"`
+	Owner Owner `json:"owner"`
+}
+
+type Owner struct {
+	ID string `json:"id"`
 }
 
 type ACL struct {
 	GrantMap []Grant `json:"grant_map"`
 }
 
-func getBucketUsers(bucketName string) (users []string, ok bool) {
-	var policy Policy
+// bucketOwnerTTL - duration the owners of buckets are cached in Redis.
+const bucketOwnerTTL = 5 * time.Minute
+
+// getBucketPolicy - loads the ACL and the owner of the bucket from RGW.
+func getBucketPolicy(bucketName string) (policy Policy, ok bool) {
 	output, err := sh.Command("radosgw-admin", "policy", "--bucket="+bucketName).Output()
 	if err != nil {
 		return
@@ -397,6 +747,15 @@ func getBucketUsers(bucketName string) (users []string, ok bool) {
 		return
 	}
 
+	return policy, true
+}
+
+func getBucketUsers(bucketName string) (users []string, ok bool) {
+	policy, ok := getBucketPolicy(bucketName)
+	if !ok {
+		return
+	}
+
 	for _, grant := range policy.ACL.GrantMap {
 		users = append(users, grant.ID)
 	}
@@ -404,6 +763,25 @@ func getBucketUsers(bucketName string) (users []string, ok bool) {
 	return users, true
 }
 
+// GetBucketOwner - returns the user owning the bucket. Owners are cached in
+// Redis since they are looked up for every event, while grants are always
+// loaded to authorize requests.
+func GetBucketOwner(bucketName string) (owner string, ok bool) {
+	client := caches.GetRedis()
+	key := fmt.Sprintf("bucket:%s:owner", bucketName)
+	if owner, err := client.Get(key).Result(); err == nil {
+		return owner, true
+	}
+
+	policy, ok := getBucketPolicy(bucketName)
+	if !ok {
+		return
+	}
+
+	client.Set(key, policy.Owner.ID, bucketOwnerTTL)
+	return policy.Owner.ID, true
+}
+
 func contains(users []string, user string) bool {
 	for _, u := range users {
 		if u == user {
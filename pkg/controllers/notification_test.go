@@ -0,0 +1,540 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio/pkg/event"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/inwinstack/kaoliang/pkg/caches"
+	"github.com/inwinstack/kaoliang/pkg/controllers"
+	"github.com/inwinstack/kaoliang/pkg/models"
+)
+
+// record - the fields of events checked by the tests.
+type record struct {
+	EventName    string `json:"eventName"`
+	UserIdentity struct {
+		PrincipalID string `json:"principalId"`
+	} `json:"userIdentity"`
+	S3 struct {
+		ConfigurationID string `json:"configurationId"`
+		Bucket          struct {
+			Name          string `json:"name"`
+			OwnerIdentity struct {
+				PrincipalID string `json:"principalId"`
+			} `json:"ownerIdentity"`
+		} `json:"bucket"`
+		Object struct {
+			Key       string `json:"key"`
+			Size      int64  `json:"size"`
+			VersionID string `json:"versionId"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+func setupNotifications() {
+	setup()
+	caches.SetRedis()
+	gin.SetMode(gin.TestMode)
+}
+
+func teardownNotifications() {
+	db := models.GetDB()
+	for _, table := range []string{"configs", "queues", "topics", "events", "s3_keys", "filter_rule_lists", "filter_rules"} {
+		db.Exec(fmt.Sprintf("TRUNCATE TABLE %s;", table))
+	}
+	teardown()
+}
+
+// newRGWServer - returns a server in place of RGW, which requests are proxied
+// to.
+func newRGWServer(handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(handler)
+	os.Setenv("TARGET_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	return server
+}
+
+// proxy - sends the request to the path through the proxy.
+func proxy(method, path, authorization string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(method, path, body)
+	So(err, ShouldBeNil)
+	req.Header.Set("Authorization", authorization)
+
+	return proxyRequest(req)
+}
+
+// proxyRequest - sends the request through the proxy.
+func proxyRequest(req *http.Request) *http.Response {
+	router := gin.New()
+	router.NoRoute(controllers.ReverseProxy())
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	target, _ := url.Parse(server.URL)
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+
+	// Redirects of POST uploads are returned to the client
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Do(req)
+	So(err, ShouldBeNil)
+	resp.Body.Close()
+
+	return resp
+}
+
+// addUser - caches the user of the access key as RGW would return it, and
+// returns the authorization header of its requests.
+func addUser(user, accessKey string) string {
+	info := fmt.Sprintf(`{"keys":[{"user":%q,"access_key":%q,"secret_key":"secret"}]}`, user, accessKey)
+	caches.GetRedis().Set("key:"+accessKey, info, time.Minute)
+
+	return fmt.Sprintf("AWS %s:signature", accessKey)
+}
+
+// addBucketOwner - caches the owner of the bucket as RGW would return it.
+func addBucketOwner(bucket, owner string) {
+	caches.GetRedis().Set(fmt.Sprintf("bucket:%s:owner", bucket), owner, time.Minute)
+}
+
+// newNotificationConfig - creates the notification configuration of the
+// bucket, which sends the events to a new queue.
+func newNotificationConfig(bucket string, eventNames ...event.Name) models.Resource {
+	db := models.GetDB()
+	queue := models.NewQueue("tester", bucket)
+	db.Create(&queue)
+	queue.PurgeMessages()
+
+	events := []models.Event{}
+	for _, name := range eventNames {
+		events = append(events, models.Event{Name: name})
+	}
+
+	db.Create(&models.Config{
+		Bucket: bucket,
+		Queues: []models.Queue{{QueueIdentifier: "notify", Events: events, ARN: queue.ARN(), Resource: queue}},
+	})
+	models.ResetObjectAccessedRules()
+
+	return queue
+}
+
+// receiveRecords - returns the events sent to the queue, by their keys.
+func receiveRecords(queue models.Resource) map[string]record {
+	messages, err := queue.ReceiveMessages(10, time.Minute)
+	So(err, ShouldBeNil)
+
+	records := map[string]record{}
+	for _, message := range messages {
+		r := record{}
+		So(json.Unmarshal([]byte(message.Body), &r), ShouldBeNil)
+		records[r.S3.Object.Key] = r
+	}
+
+	return records
+}
+
+// versioningHandler - answers requests of a bucket with the versioning
+// status, and quiet multi-object deletes with no errors.
+func versioningHandler(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.URL.Query()["versioning"]; ok {
+			fmt.Fprintf(w, `<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">%s</VersioningConfiguration>`, status)
+			return
+		}
+
+		fmt.Fprint(w, `<DeleteResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></DeleteResult>`)
+	}
+}
+
+const quietDeleteBody = `<Delete><Quiet>true</Quiet>` +
+	`<Object><Key>a.jpg</Key></Object>` +
+	`<Object><Key>b.jpg</Key><VersionId>v1</VersionId></Object>` +
+	`</Delete>`
+
+func TestQuietDeleteEvents(t *testing.T) {
+	setupNotifications()
+	defer teardownNotifications()
+
+	Convey("Given a versioned bucket with notifications of removed objects", t, func() {
+		server := newRGWServer(versioningHandler("<Status>Enabled</Status>"))
+		defer server.Close()
+
+		queue := newNotificationConfig("versioned", event.ObjectRemovedDelete, models.ObjectRemovedDeleteMarkerCreated)
+		addBucketOwner("versioned", "owner")
+		authorization := addUser("tester", "tester-key")
+
+		Convey("When objects are deleted quietly", func() {
+			resp := proxy("POST", "/versioned?delete", authorization, strings.NewReader(quietDeleteBody))
+			records := receiveRecords(queue)
+
+			Convey("Deletes without versions should create delete markers", func() {
+				So(resp.StatusCode, ShouldEqual, 200)
+				So(records, ShouldHaveLength, 2)
+				So(records["a.jpg"].EventName, ShouldEqual, "s3:ObjectRemoved:DeleteMarkerCreated")
+				So(records["b.jpg"].EventName, ShouldEqual, "s3:ObjectRemoved:Delete")
+				So(records["b.jpg"].S3.Object.VersionID, ShouldEqual, "v1")
+			})
+		})
+	})
+
+	Convey("Given an unversioned bucket with notifications of removed objects", t, func() {
+		server := newRGWServer(versioningHandler(""))
+		defer server.Close()
+
+		queue := newNotificationConfig("unversioned", event.ObjectRemovedDelete, models.ObjectRemovedDeleteMarkerCreated)
+		addBucketOwner("unversioned", "owner")
+		authorization := addUser("tester", "tester-key")
+
+		Convey("When objects are deleted quietly", func() {
+			proxy("POST", "/unversioned?delete", authorization, strings.NewReader(quietDeleteBody))
+			records := receiveRecords(queue)
+
+			Convey("The objects should be deleted", func() {
+				So(records, ShouldHaveLength, 2)
+				So(records["a.jpg"].EventName, ShouldEqual, "s3:ObjectRemoved:Delete")
+				So(records["b.jpg"].EventName, ShouldEqual, "s3:ObjectRemoved:Delete")
+			})
+		})
+	})
+}
+
+// versionedHandler - answers requests of objects in a versioned bucket, where
+// deletes without versions create delete markers.
+func versionedHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "PUT":
+		w.Header().Set("Etag", `"3858f62230ac3c915f300c664312c63f"`)
+		w.Header().Set("X-Amz-Version-Id", "v2")
+	case "DELETE":
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+		if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+			w.Header().Set("X-Amz-Version-Id", versionID)
+		} else {
+			w.Header().Set("X-Amz-Version-Id", "m1")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestVersionedObjectEvents(t *testing.T) {
+	setupNotifications()
+	defer teardownNotifications()
+
+	Convey("Given a versioned bucket with notifications of created and removed objects", t, func() {
+		server := newRGWServer(versionedHandler)
+		defer server.Close()
+
+		queue := newNotificationConfig("versioned", event.ObjectCreatedPut, event.ObjectRemovedDelete, models.ObjectRemovedDeleteMarkerCreated)
+		addBucketOwner("versioned", "owner")
+		authorization := addUser("tester:subuser", "tester-key")
+
+		Reset(teardownNotifications)
+
+		Convey("When an object is put", func() {
+			proxy("PUT", "/versioned/a.jpg", authorization, strings.NewReader("foobar"))
+			records := receiveRecords(queue)
+
+			Convey("The event should have the version of the object", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["a.jpg"].EventName, ShouldEqual, "s3:ObjectCreated:Put")
+				So(records["a.jpg"].S3.Object.VersionID, ShouldEqual, "v2")
+				So(records["a.jpg"].S3.Object.Size, ShouldEqual, 6)
+				So(records["a.jpg"].UserIdentity.PrincipalID, ShouldEqual, "tester")
+				So(records["a.jpg"].S3.Bucket.OwnerIdentity.PrincipalID, ShouldEqual, "owner")
+				So(records["a.jpg"].S3.ConfigurationID, ShouldEqual, "notify")
+			})
+		})
+
+		Convey("When an object is deleted without its version", func() {
+			proxy("DELETE", "/versioned/a.jpg", authorization, nil)
+			records := receiveRecords(queue)
+
+			Convey("The event should have the version of the delete marker", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["a.jpg"].EventName, ShouldEqual, "s3:ObjectRemoved:DeleteMarkerCreated")
+				So(records["a.jpg"].S3.Object.VersionID, ShouldEqual, "m1")
+			})
+		})
+
+		Convey("When a delete marker is deleted by its version", func() {
+			proxy("DELETE", "/versioned/a.jpg?versionId=m1", authorization, nil)
+			records := receiveRecords(queue)
+
+			Convey("The delete marker should be deleted", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["a.jpg"].EventName, ShouldEqual, "s3:ObjectRemoved:Delete")
+				So(records["a.jpg"].S3.Object.VersionID, ShouldEqual, "m1")
+			})
+		})
+	})
+}
+
+// objectHandler - answers reads of an object with the body foobar.
+func objectHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Etag", `"3858f62230ac3c915f300c664312c63f"`)
+	if r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Range", "bytes 0-2/6")
+		w.WriteHeader(http.StatusPartialContent)
+		fmt.Fprint(w, "foo")
+		return
+	}
+
+	w.Header().Set("Content-Length", "6")
+	if r.Method == "GET" {
+		fmt.Fprint(w, "foobar")
+	}
+}
+
+func TestAccessEvents(t *testing.T) {
+	setupNotifications()
+	defer teardownNotifications()
+
+	Convey("Given a bucket with notifications of accessed objects and a bucket without them", t, func() {
+		server := newRGWServer(objectHandler)
+		defer server.Close()
+
+		queue := newNotificationConfig("watched", event.ObjectAccessedAll)
+		otherQueue := newNotificationConfig("unwatched", event.ObjectCreatedPut)
+		addBucketOwner("watched", "owner")
+		authorization := addUser("tester:subuser", "tester-key")
+
+		Reset(teardownNotifications)
+
+		Convey("Only the bucket with them should look up its rules on reads", func() {
+			So(models.HasObjectAccessedRules("watched"), ShouldBeTrue)
+			So(models.HasObjectAccessedRules("unwatched"), ShouldBeFalse)
+		})
+
+		Convey("When an object is read", func() {
+			proxy("GET", "/watched/a.jpg", authorization, nil)
+			proxy("HEAD", "/watched/b.jpg", authorization, nil)
+			proxy("GET", "/unwatched/a.jpg", authorization, nil)
+			records := receiveRecords(queue)
+			otherRecords := receiveRecords(otherQueue)
+
+			Convey("The events of the accesses should be sent", func() {
+				So(records, ShouldHaveLength, 2)
+				So(records["a.jpg"].EventName, ShouldEqual, "s3:ObjectAccessed:Get")
+				So(records["a.jpg"].S3.Object.Size, ShouldEqual, 6)
+				So(records["a.jpg"].UserIdentity.PrincipalID, ShouldEqual, "tester")
+				So(records["a.jpg"].S3.Bucket.OwnerIdentity.PrincipalID, ShouldEqual, "owner")
+				So(records["b.jpg"].EventName, ShouldEqual, "s3:ObjectAccessed:Head")
+				So(records["b.jpg"].S3.Object.Size, ShouldEqual, 6)
+				So(otherRecords, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a part of an object is read", func() {
+			req, _ := http.NewRequest("GET", "/watched/a.jpg", nil)
+			req.Header.Set("Authorization", authorization)
+			req.Header.Set("Range", "bytes=0-2")
+			proxyRequest(req)
+			records := receiveRecords(queue)
+
+			Convey("The event should have the size of the object", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["a.jpg"].EventName, ShouldEqual, "s3:ObjectAccessed:Get")
+				So(records["a.jpg"].S3.Object.Size, ShouldEqual, 6)
+			})
+		})
+
+		Convey("When a subresource of an object or the bucket is read", func() {
+			proxy("GET", "/watched/a.jpg?acl", authorization, nil)
+			proxy("GET", "/watched/", authorization, nil)
+			records := receiveRecords(queue)
+
+			Convey("No events should be sent", func() {
+				So(records, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+// postForm - returns the body and the content type of a POST upload of the
+// file with the fields.
+func postForm(fields map[string]string, filename, content string) (io.Reader, string) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	file, _ := form.CreateFormFile("file", filename)
+	io.WriteString(file, content)
+	form.Close()
+
+	return body, form.FormDataContentType()
+}
+
+// postHandler - answers POST uploads as success_action_status and
+// success_action_redirect of their forms ask, with keys of the responses
+// telling where they are taken from.
+func postHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Etag", `"3858f62230ac3c915f300c664312c63f"`)
+	if redirect := r.FormValue("success_action_redirect"); redirect != "" {
+		http.Redirect(w, r, redirect+"?bucket=posted&key=from-redirect.jpg&etag=%22redirected%22", http.StatusSeeOther)
+		return
+	}
+
+	switch r.FormValue("success_action_status") {
+	case "201":
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `<PostResponse><Bucket>posted</Bucket><Key>from-body.jpg</Key><ETag>"created"</ETag></PostResponse>`)
+	case "204":
+		w.Header().Set("Location", "http://cloud.inwinstack.com/posted/from-location.jpg")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestPostEvents(t *testing.T) {
+	setupNotifications()
+	defer teardownNotifications()
+
+	Convey("Given a bucket with notifications of posted objects", t, func() {
+		server := newRGWServer(postHandler)
+		defer server.Close()
+
+		queue := newNotificationConfig("posted", event.ObjectCreatedPost)
+		addBucketOwner("posted", "owner")
+		addUser("tester:subuser", "tester-key")
+
+		// post - uploads the file with the form, which is authenticated by
+		// the access key in it.
+		post := func(fields map[string]string) map[string]record {
+			fields["key"] = "uploads/${filename}"
+			fields["AWSAccessKeyId"] = "tester-key"
+			body, contentType := postForm(fields, "a.jpg", "foobar")
+			req, _ := http.NewRequest("POST", "/posted", body)
+			req.Header.Set("Content-Type", contentType)
+			proxyRequest(req)
+
+			return receiveRecords(queue)
+		}
+
+		Reset(teardownNotifications)
+
+		Convey("When an object is posted without a key in the response", func() {
+			records := post(map[string]string{})
+
+			Convey("The key of the form should be used with the name of the file", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["uploads/a.jpg"].EventName, ShouldEqual, "s3:ObjectCreated:Post")
+				So(records["uploads/a.jpg"].S3.Object.Size, ShouldEqual, 6)
+				So(records["uploads/a.jpg"].UserIdentity.PrincipalID, ShouldEqual, "tester")
+				So(records["uploads/a.jpg"].S3.Bucket.OwnerIdentity.PrincipalID, ShouldEqual, "owner")
+			})
+		})
+
+		Convey("When an object is posted with success_action_status 201", func() {
+			records := post(map[string]string{"success_action_status": "201"})
+
+			Convey("The key of the response body should be used", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["from-body.jpg"].S3.Object.Size, ShouldEqual, 6)
+			})
+		})
+
+		Convey("When an object is posted with success_action_status 204", func() {
+			records := post(map[string]string{"success_action_status": "204"})
+
+			Convey("The key of the Location header should be used", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["from-location.jpg"].EventName, ShouldEqual, "s3:ObjectCreated:Post")
+			})
+		})
+
+		Convey("When an object is posted with success_action_redirect", func() {
+			records := post(map[string]string{"success_action_redirect": "http://example.com/done"})
+
+			Convey("The key of the redirect should be used", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["from-redirect.jpg"].EventName, ShouldEqual, "s3:ObjectCreated:Post")
+			})
+		})
+	})
+
+	Convey("Given a bucket with notifications of posted objects, which answers before the form is read", t, func() {
+		server := newRGWServer(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `<PostResponse><Bucket>posted</Bucket><Key>from-body.jpg</Key><ETag>"created"</ETag></PostResponse>`)
+		})
+		defer server.Close()
+
+		queue := newNotificationConfig("posted", event.ObjectCreatedPost)
+		addBucketOwner("posted", "owner")
+
+		Reset(teardownNotifications)
+
+		Convey("When the file of the form is sent after the response", func() {
+			pr, pw := io.Pipe()
+			form := multipart.NewWriter(pw)
+			go func() {
+				// The proxy waits 5 seconds for the form
+				form.WriteField("key", "uploads/${filename}")
+				time.Sleep(6 * time.Second)
+				file, _ := form.CreateFormFile("file", "a.jpg")
+				io.WriteString(file, "foobar")
+				form.Close()
+				pw.Close()
+			}()
+
+			req, _ := http.NewRequest("POST", "/posted", pr)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			proxyRequest(req)
+			records := receiveRecords(queue)
+
+			Convey("The key of the response should be used once waiting for the form times out", func() {
+				So(records, ShouldHaveLength, 1)
+				So(records["from-body.jpg"].EventName, ShouldEqual, "s3:ObjectCreated:Post")
+				So(records["from-body.jpg"].S3.Object.Size, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestGetBucketOwner(t *testing.T) {
+	setupNotifications()
+
+	Convey("Given a bucket whose owner is cached", t, func() {
+		addBucketOwner("owned", "owner")
+
+		Convey("Its owner should be returned", func() {
+			owner, ok := controllers.GetBucketOwner("owned")
+			So(ok, ShouldBeTrue)
+			So(owner, ShouldEqual, "owner")
+		})
+	})
+
+	Convey("Given a bucket which does not exist", t, func() {
+		caches.GetRedis().Del("bucket:missing:owner")
+
+		Convey("No owner should be returned or cached", func() {
+			owner, ok := controllers.GetBucketOwner("missing")
+			So(ok, ShouldBeFalse)
+			So(owner, ShouldBeEmpty)
+			So(caches.GetRedis().Exists("bucket:missing:owner").Val(), ShouldEqual, 0)
+		})
+	})
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/inwinstack/kaoliang/pkg/utils"
 	"github.com/minio/minio/cmd"
+	"github.com/minio/minio/pkg/auth"
 )
 
 type CopyObject struct {
@@ -46,6 +47,23 @@ type MoveObjectsResponse struct {
 	Error []MoveError  `json:"error"`
 }
 
+// newS3Client - creates a client of RGW with the credentials of the user.
+func newS3Client(creds auth.Credentials) *s3.S3 {
+	sess, _ := session.NewSession(&aws.Config{
+		Region:     aws.String(utils.GetEnv("RGW_REGION", "us-east-1")),
+		Endpoint:   aws.String(utils.GetEnv("TARGET_HOST", "http://127.0.0.1:7480")),
+		DisableSSL: aws.Bool(true),
+		Credentials: credentials.NewStaticCredentials(
+			creds.AccessKey,
+			creds.SecretKey,
+			"",
+		),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+
+	return s3.New(sess)
+}
+
 func deleteObject(client *s3.S3, bucket, key string) error {
 	deleteObjectInput := &s3.DeleteObjectInput{
 		Bucket: aws.String(bucket),
@@ -97,19 +115,7 @@ func MoveObjects(c *gin.Context) {
 		return
 	}
 
-	sess, _ := session.NewSession(&aws.Config{
-		Region:     aws.String(utils.GetEnv("RGW_REGION", "us-east-1")),
-		Endpoint:   aws.String(utils.GetEnv("TARGET_HOST", "http://127.0.0.1:7480")),
-		DisableSSL: aws.Bool(true),
-		Credentials: credentials.NewStaticCredentials(
-			creds.AccessKey,
-			creds.SecretKey,
-			"",
-		),
-		S3ForcePathStyle: aws.Bool(true),
-	})
-
-	client := s3.New(sess)
+	client := newS3Client(creds)
 	numOfObjs := len(objects)
 
 	jobs := make(chan CopyObject, numOfObjs)
@@ -0,0 +1,196 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio/pkg/event"
+)
+
+// postUploadTimeout - duration to wait for the form of a POST upload to be
+// read after the upload succeeded.
+const postUploadTimeout = 5 * time.Second
+
+// postUpload - a browser-based POST upload, whose form is read while the
+// request is proxied to find the key and the size of the uploaded file.
+type postUpload struct {
+	key       string
+	filename  string
+	accessKey string
+	size      int64
+	done      chan struct{}
+}
+
+// postResponse - the response of POST uploads with success_action_status 201.
+type postResponse struct {
+	Key  string
+	ETag string
+}
+
+// isPostUpload - reports whether the request uploads an object with an HTML
+// form, which is posted to the bucket.
+func isPostUpload(req *http.Request) bool {
+	_, objectName, _ := getObjectName(req)
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	return req.Method == "POST" && objectName == "" && len(req.URL.Query()) == 0 && mediaType == "multipart/form-data" && req.Body != nil
+}
+
+// isPostUploadSuccess - reports whether the upload succeeded, which responds
+// by success_action_status or redirects to success_action_redirect.
+func isPostUploadSuccess(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent, http.StatusSeeOther:
+		return true
+	}
+
+	return false
+}
+
+// watchPostUpload - reads the form of the upload as the request body is sent
+// to the backend.
+func watchPostUpload(req *http.Request) *postUpload {
+	_, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	pr, pw := io.Pipe()
+	req.Body = &teeReadCloser{
+		Reader: io.TeeReader(req.Body, pw),
+		body:   req.Body,
+		pipe:   pw,
+	}
+
+	upload := &postUpload{done: make(chan struct{})}
+	go func() {
+		defer close(upload.done)
+		upload.read(multipart.NewReader(pr, params["boundary"]))
+
+		// The rest of the body must be read for the request to be sent
+		io.Copy(ioutil.Discard, pr)
+	}()
+
+	return upload
+}
+
+// read - reads the key and the file of the form, fields after the file are
+// ignored like S3 does.
+func (u *postUpload) read(form *multipart.Reader) {
+	for {
+		part, err := form.NextPart()
+		if err != nil {
+			return
+		}
+
+		switch strings.ToLower(part.FormName()) {
+		case "key":
+			value, _ := ioutil.ReadAll(io.LimitReader(part, 1024))
+			u.key = string(value)
+		case "awsaccesskeyid":
+			value, _ := ioutil.ReadAll(io.LimitReader(part, 1024))
+			u.accessKey = string(value)
+		case "x-amz-credential":
+			value, _ := ioutil.ReadAll(io.LimitReader(part, 1024))
+			u.accessKey = ExtractAccessKeyV4("AWS4-HMAC-SHA256 Credential=" + string(value))
+		case "file":
+			u.filename = part.FileName()
+			u.size, _ = io.Copy(ioutil.Discard, part)
+			return
+		}
+	}
+}
+
+// teeReadCloser - a request body that is copied to the pipe as it is read.
+type teeReadCloser struct {
+	io.Reader
+	body io.Closer
+	pipe *io.PipeWriter
+}
+
+func (r *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.pipe.Close()
+	}
+
+	return n, err
+}
+
+func (r *teeReadCloser) Close() error {
+	r.pipe.Close()
+	return r.body.Close()
+}
+
+// sendPostEvent - sends ObjectCreated:Post for the successful upload. The
+// final key is taken from the response, which is the 201 XML body, the
+// Location header, or the redirect to success_action_redirect, and from the
+// key of the form otherwise.
+func sendPostEvent(resp *http.Response, upload *postUpload) error {
+	var key, accessKey string
+	var size int64
+	select {
+	case <-upload.done:
+		key = strings.Replace(upload.key, "${filename}", upload.filename, -1)
+		accessKey, size = upload.accessKey, upload.size
+	case <-time.After(postUploadTimeout):
+	}
+
+	etag := resp.Header.Get("Etag")
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	switch {
+	case resp.StatusCode == http.StatusCreated:
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(b)) // put body back for client response
+		if err != nil {
+			return err
+		}
+
+		result := postResponse{}
+		if err := xml.Unmarshal(b, &result); err == nil && result.Key != "" {
+			key, etag = result.Key, result.ETag
+		}
+	case resp.StatusCode == http.StatusSeeOther && location != nil:
+		if value := location.Query().Get("key"); value != "" {
+			key, etag = value, location.Query().Get("etag")
+		}
+	case location != nil && location.Path != "":
+		if _, objectName, _ := getObjectName(&http.Request{Host: location.Host, URL: location}); objectName != "" {
+			key = objectName
+		}
+	}
+
+	if key == "" {
+		return nil
+	}
+
+	return sendEvents(resp, []objectEvent{{
+		name: event.ObjectCreatedPost,
+		object: event.Object{
+			Key:       key,
+			Size:      size,
+			ETag:      etag,
+			VersionID: resp.Header.Get("X-Amz-Version-Id"),
+		},
+		accessKey: accessKey,
+	}})
+}
@@ -15,22 +15,46 @@ Licensed under the Apache License, Version 2.0 (the "License");
 package controllers
 
 import (
-	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio/cmd"
 	"github.com/satori/go.uuid"
 
-	"github.com/inwinstack/kaoliang/pkg/caches"
 	"github.com/inwinstack/kaoliang/pkg/models"
 )
 
+const (
+	maxBatchEntries      = 10
+	maxMessageSize       = 262144
+	maxVisibilityTimeout = 43200
+	maxWaitTimeSeconds   = 20
+	maxListQueuesResults = 1000
+	maxMessageAttributes = 10
+	maxDelaySeconds      = 900
+)
+
+const senderIDKey = "SenderId"
+
+// sqsCodePrefix - prefix of error codes specific to SQS.
+const sqsCodePrefix = "AWS.SimpleQueueService."
+
+var (
+	batchEntryIDRegexp  = regexp.MustCompile("^[\\w-]{1,80}$")
+	attributeNameRegexp = regexp.MustCompile("^[\\w-]+(\\.[\\w-]+)*$")
+	attributeTypeRegexp = regexp.MustCompile("^(String|Number|Binary)(\\..+)?$")
+	queueNameRegexp     = regexp.MustCompile("^[\\w-]{1,80}$|^[\\w-]{1,75}\\.fifo$")
+)
+
 func ListQueues(c *gin.Context) {
 	accountID, errCode := authenticate(c.Request)
 	if errCode != cmd.ErrNone {
@@ -44,11 +68,44 @@ func ListQueues(c *gin.Context) {
 	}
 
 	db := models.GetDB()
-	var queues []models.Resource
-	db.Where(&models.Resource{
+	query := db.Where(&models.Resource{
 		Service:   models.SQS,
 		AccountID: accountID,
-	}).Find(&queues)
+	}).Order("id")
+
+	if prefix := formValue(c, "QueueNamePrefix"); prefix != "" {
+		query = query.Where("name LIKE ?", escapeLike(prefix)+"%")
+	}
+
+	// Pagination is only used when MaxResults is given
+	maxResults := 0
+	if value := formValue(c, "MaxResults"); value != "" {
+		var err error
+		maxResults, err = strconv.Atoi(value)
+		if err != nil || maxResults < 1 || maxResults > maxListQueuesResults {
+			writeSenderErrorResponse(c, "InvalidParameterValue", fmt.Sprintf("Value %s for parameter MaxResults is invalid. Reason: MaxResults must be an integer between 1 and %d.", value, maxListQueuesResults))
+			return
+		}
+		query = query.Limit(maxResults + 1)
+	}
+
+	if token := formValue(c, "NextToken"); token != "" {
+		id, ok := decodeNextToken(token)
+		if !ok {
+			writeSenderErrorResponse(c, "InvalidParameterValue", "Invalid NextToken value.")
+			return
+		}
+		query = query.Where("id > ?", id)
+	}
+
+	var queues []models.Resource
+	query.Find(&queues)
+
+	var nextToken string
+	if maxResults > 0 && len(queues) > maxResults {
+		queues = queues[:maxResults]
+		nextToken = encodeNextToken(queues[maxResults-1].ID)
+	}
 
 	queueUrls := []string{}
 	for _, queue := range queues {
@@ -58,6 +115,7 @@ func ListQueues(c *gin.Context) {
 	requestID, _ := uuid.NewV4()
 	body := ListQueuesResponse{
 		QueueURLs: queueUrls,
+		NextToken: nextToken,
 		RequestID: requestID.String(),
 	}
 
@@ -85,39 +143,64 @@ func CreateQueue(c *gin.Context) {
 	}
 	db := models.GetDB()
 
-	queue := models.Resource{
-		Service:   models.SQS,
-		AccountID: accountID,
-		Name:      queueName,
-	}
+	queue := models.NewQueue(accountID, queueName)
 
 	requestID, _ := uuid.NewV4()
 
-	re := regexp.MustCompile("^[\\w-]{1,80}$")
-	if !re.MatchString(queueName) {
+	if !queueNameRegexp.MatchString(queueName) {
 		body := ErrorResponse{
 			Type:      "Sender",
 			Code:      "InvalidParameterValue",
-			Message:   "Can only include alphanumeric characters, hyphens, or underscores. 1 to 80 in length",
+			Message:   "Can only include alphanumeric characters, hyphens, or underscores. 1 to 80 in length, the name of a FIFO queue must end with the .fifo suffix",
 			RequestID: requestID.String(),
 		}
 		c.XML(http.StatusBadRequest, body)
 		return
 	}
 
-	// Response Error when queue is exists
-	if !db.Where(&queue).First(&models.Resource{}).RecordNotFound() {
+	names := []string{}
+	fifoQueue := false
+	for _, attribute := range parseEntries(formValues(c), "Attribute") {
+		name := attribute.Get("Name")
+		if err := queue.SetAttribute(name, attribute.Get("Value")); err != nil {
+			writeAttributeErrorResponse(c, name, err)
+			return
+		}
+		names = append(names, name)
+		fifoQueue = fifoQueue || name == "FifoQueue"
+	}
+
+	// FIFO queues must be created with the FifoQueue attribute
+	if queue.FifoQueue && !fifoQueue {
 		body := ErrorResponse{
 			Type:      "Sender",
-			Code:      "QueueAlreadyExists",
-			Message:   "A queue with this name already exists.",
+			Code:      "InvalidParameterValue",
+			Message:   "The name of a FIFO queue can only end with the .fifo suffix when the FifoQueue attribute is true.",
 			RequestID: requestID.String(),
 		}
 		c.XML(http.StatusBadRequest, body)
 		return
 	}
 
-	db.Create(&queue)
+	// Response the existing queue when it has the same attributes, otherwise
+	// response error
+	existingQueue := models.Resource{}
+	if !db.Where(models.Resource{Service: models.SQS, AccountID: accountID, Name: queueName}).First(&existingQueue).RecordNotFound() {
+		if !existingQueue.MatchAttributes(queue, names) {
+			body := ErrorResponse{
+				Type:      "Sender",
+				Code:      "QueueAlreadyExists",
+				Message:   "A queue with this name already exists.",
+				RequestID: requestID.String(),
+			}
+			c.XML(http.StatusBadRequest, body)
+			return
+		}
+		queue = existingQueue
+	} else {
+		db.Create(&queue)
+	}
+
 	body := CreateQueueResponse{
 		QueueURL:  queue.URL(),
 		RequestID: requestID.String(),
@@ -172,6 +255,7 @@ func DeleteQueue(c *gin.Context) {
 	}
 
 	db.Delete(&queue)
+	queue.DeleteMessages()
 
 	body := DeleteQueueResponse{
 		RequestID: requestID.String(),
@@ -180,7 +264,9 @@ func DeleteQueue(c *gin.Context) {
 	c.XML(http.StatusOK, body)
 }
 
-func ReceiveMessage(c *gin.Context) {
+// GetQueueURL - returns the URL of the queue with the name, the queue is
+// looked up in the account of QueueOwnerAWSAccountId when it is given.
+func GetQueueURL(c *gin.Context) {
 	userID, errCode := authenticate(c.Request)
 	if errCode != cmd.ErrNone {
 		writeErrorResponse(c, errCode)
@@ -192,17 +278,9 @@ func ReceiveMessage(c *gin.Context) {
 		userID = tokens[0]
 	}
 
-	var accountID string
-	var queueName string
-	switch c.Request.Method {
-	case "GET":
-		accountID = c.Param("account_id")
-		queueName = c.Param("queue_name")
-	case "POST":
-		queueURL, _ := url.Parse(c.PostForm("QueueUrl"))
-		segments := strings.Split(queueURL.Path, "/")
-		accountID = segments[1]
-		queueName = segments[2]
+	accountID := formValue(c, "QueueOwnerAWSAccountId")
+	if accountID == "" {
+		accountID = userID
 	}
 
 	if userID != accountID {
@@ -212,20 +290,46 @@ func ReceiveMessage(c *gin.Context) {
 
 	db := models.GetDB()
 	queue := models.Resource{}
+	if db.Where(models.Resource{Service: models.SQS, AccountID: accountID, Name: formValue(c, "QueueName")}).First(&queue).RecordNotFound() {
+		writeSenderErrorResponse(c, "AWS.SimpleQueueService.NonExistentQueue", "The specified queue does not exist for this wsdl version.")
+		return
+	}
 
-	err := db.Where(models.Resource{Service: models.SQS, AccountID: accountID, Name: queueName}).First(&queue).Error
-	if err != nil {
-		c.XML(http.StatusOK, nil)
+	requestID, _ := uuid.NewV4()
+	response := GetQueueURLResponse{
+		QueueURL:  queue.URL(),
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+// PurgeQueue - deletes all messages in the queue, including the messages
+// that are received but not deleted yet.
+func PurgeQueue(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
 	}
 
-	var maxNumberOfMessages string
-	switch c.Request.Method {
-	case "GET":
-		maxNumberOfMessages = c.Query("MaxNumberOfMessages")
-	case "POST":
-		maxNumberOfMessages = c.PostForm("MaxNumberOfMessages")
+	if err := queue.PurgeMessages(); err != nil {
+		writeErrorResponse(c, cmd.ErrInternalError)
+		return
 	}
-	maxMsgNum, err := strconv.Atoi(maxNumberOfMessages)
+
+	requestID, _ := uuid.NewV4()
+	response := PurgeQueueResponse{
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+func ReceiveMessage(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	maxMsgNum, err := strconv.Atoi(formValue(c, "MaxNumberOfMessages"))
 	if err != nil || maxMsgNum <= 0 {
 		maxMsgNum = 1
 	}
@@ -233,23 +337,70 @@ func ReceiveMessage(c *gin.Context) {
 		maxMsgNum = 10
 	}
 
-	redis := caches.GetRedis()
-	key := fmt.Sprintf("sqs:%s:%s", accountID, queueName)
-	bodys, _ := redis.LRange(key, 0, int64(maxMsgNum-1)).Result()
-	redis.LTrim(key, int64(maxMsgNum), -1)
+	visibilityTimeout := queue.VisibilityTimeout
+	if value := formValue(c, "VisibilityTimeout"); value != "" {
+		visibilityTimeout, ok = parseVisibilityTimeout(value)
+		if !ok {
+			writeSenderErrorResponse(c, "InvalidParameterValue", fmt.Sprintf("Value %s for parameter VisibilityTimeout is invalid. Reason: Must be between 0 and %d.", value, maxVisibilityTimeout))
+			return
+		}
+	}
 
-	msgs := []Message{}
-	for _, body := range bodys {
-		bodyMd5 := md5.Sum([]byte(body))
-		msgId, _ := uuid.NewV4()
-		receiptHandle := ""
+	waitTimeSeconds := queue.ReceiveMessageWaitTimeSeconds
+	if value := formValue(c, "WaitTimeSeconds"); value != "" {
+		waitTimeSeconds, ok = parseWaitTimeSeconds(value)
+		if !ok {
+			writeSenderErrorResponse(c, "InvalidParameterValue", fmt.Sprintf("Value %s for parameter WaitTimeSeconds is invalid. Reason: Must be >= 0 and <= %d, if provided.", value, maxWaitTimeSeconds))
+			return
+		}
+	}
 
+	messages, err := queue.WaitMessages(c.Request.Context(), maxMsgNum, time.Duration(visibilityTimeout)*time.Second, time.Duration(waitTimeSeconds)*time.Second)
+	if err != nil {
+		writeErrorResponse(c, cmd.ErrInternalError)
+		return
+	}
+
+	values := formValues(c)
+	attributeNames := parseEntries(values, "AttributeName")
+	attributeNames = append(attributeNames, parseEntries(values, "MessageSystemAttributeName")...)
+	messageAttributeNames := parseEntries(values, "MessageAttributeName")
+
+	msgs := []Message{}
+	for _, message := range messages {
 		msg := Message{
-			MessageID:     msgId.String(),
-			ReceiptHandle: receiptHandle,
-			Body:          body,
-			MD5OfBody:     fmt.Sprintf("%x", bodyMd5),
+			MessageID:     message.ID,
+			ReceiptHandle: message.ReceiptHandle,
+			Body:          message.Body,
+			MD5OfBody:     message.MD5OfBody(),
+		}
+
+		systemAttributes := message.SystemAttributes()
+		for _, name := range models.MessageSystemAttributeNames {
+			if _, ok := systemAttributes[name]; ok && matchAttributeName(attributeNames, name) {
+				msg.Attributes = append(msg.Attributes, Attribute{Name: name, Value: systemAttributes[name]})
+			}
 		}
+
+		// Only the returned message attributes are digested
+		returned := models.Message{MessageAttributes: map[string]models.MessageAttribute{}}
+		for name, attribute := range message.MessageAttributes {
+			if matchAttributeName(messageAttributeNames, name) {
+				returned.MessageAttributes[name] = attribute
+			}
+		}
+		for _, name := range sortedAttributeNames(returned.MessageAttributes) {
+			attribute := returned.MessageAttributes[name]
+			value := MessageAttributeValue{DataType: attribute.DataType}
+			if attribute.IsBinary() {
+				value.BinaryValue = base64.StdEncoding.EncodeToString(attribute.BinaryValue)
+			} else {
+				value.StringValue = attribute.StringValue
+			}
+			msg.MessageAttributes = append(msg.MessageAttributes, MessageAttribute{Name: name, Value: value})
+		}
+		msg.MD5OfMessageAttributes = returned.MD5OfMessageAttributes()
+
 		msgs = append(msgs, msg)
 	}
 
@@ -260,3 +411,684 @@ func ReceiveMessage(c *gin.Context) {
 	}
 	c.XML(http.StatusOK, response)
 }
+
+func DeleteMessage(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	if err := queue.DeleteMessage(formValue(c, "ReceiptHandle")); err != nil {
+		writeMessageErrorResponse(c, err)
+		return
+	}
+
+	requestID, _ := uuid.NewV4()
+	response := DeleteMessageResponse{
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+func DeleteMessageBatch(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	entries := parseEntries(formValues(c), "DeleteMessageBatchRequestEntry")
+	if code, message := validateBatchEntries(entries, sqsCodePrefix); code != "" {
+		writeSenderErrorResponse(c, code, message)
+		return
+	}
+
+	response := DeleteMessageBatchResponse{
+		Successful: []BatchResultEntry{},
+		Failed:     []BatchResultErrorEntry{},
+	}
+
+	for _, entry := range entries {
+		if err := queue.DeleteMessage(entry.Get("ReceiptHandle")); err != nil {
+			response.Failed = append(response.Failed, newBatchResultErrorEntry(entry.Get("Id"), err))
+			continue
+		}
+
+		response.Successful = append(response.Successful, BatchResultEntry{ID: entry.Get("Id")})
+	}
+
+	requestID, _ := uuid.NewV4()
+	response.RequestID = requestID.String()
+	c.XML(http.StatusOK, response)
+}
+
+func ChangeMessageVisibility(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	value := formValue(c, "VisibilityTimeout")
+	visibilityTimeout, ok := parseVisibilityTimeout(value)
+	if !ok {
+		writeSenderErrorResponse(c, "InvalidParameterValue", fmt.Sprintf("Value %s for parameter VisibilityTimeout is invalid. Reason: Must be between 0 and %d.", value, maxVisibilityTimeout))
+		return
+	}
+
+	if err := queue.ChangeMessageVisibility(formValue(c, "ReceiptHandle"), time.Duration(visibilityTimeout)*time.Second); err != nil {
+		writeMessageErrorResponse(c, err)
+		return
+	}
+
+	requestID, _ := uuid.NewV4()
+	response := ChangeMessageVisibilityResponse{
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+func ChangeMessageVisibilityBatch(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	entries := parseEntries(formValues(c), "ChangeMessageVisibilityBatchRequestEntry")
+	if code, message := validateBatchEntries(entries, sqsCodePrefix); code != "" {
+		writeSenderErrorResponse(c, code, message)
+		return
+	}
+
+	response := ChangeMessageVisibilityBatchResponse{
+		Successful: []BatchResultEntry{},
+		Failed:     []BatchResultErrorEntry{},
+	}
+
+	for _, entry := range entries {
+		value := entry.Get("VisibilityTimeout")
+		visibilityTimeout, ok := parseVisibilityTimeout(value)
+		if !ok {
+			response.Failed = append(response.Failed, BatchResultErrorEntry{
+				ID:          entry.Get("Id"),
+				Code:        "InvalidParameterValue",
+				Message:     fmt.Sprintf("Value %s for parameter VisibilityTimeout is invalid. Reason: Must be between 0 and %d.", value, maxVisibilityTimeout),
+				SenderFault: true,
+			})
+			continue
+		}
+
+		if err := queue.ChangeMessageVisibility(entry.Get("ReceiptHandle"), time.Duration(visibilityTimeout)*time.Second); err != nil {
+			response.Failed = append(response.Failed, newBatchResultErrorEntry(entry.Get("Id"), err))
+			continue
+		}
+
+		response.Successful = append(response.Successful, BatchResultEntry{ID: entry.Get("Id")})
+	}
+
+	requestID, _ := uuid.NewV4()
+	response.RequestID = requestID.String()
+	c.XML(http.StatusOK, response)
+}
+
+// parseVisibilityTimeout - returns visibility timeout in seconds.
+func parseVisibilityTimeout(value string) (int, bool) {
+	visibilityTimeout, err := strconv.Atoi(value)
+	if err != nil || visibilityTimeout < 0 || visibilityTimeout > maxVisibilityTimeout {
+		return 0, false
+	}
+
+	return visibilityTimeout, true
+}
+
+// parseWaitTimeSeconds - returns long polling wait time in seconds.
+func parseWaitTimeSeconds(value string) (int, bool) {
+	waitTimeSeconds, err := strconv.Atoi(value)
+	if err != nil || waitTimeSeconds < 0 || waitTimeSeconds > maxWaitTimeSeconds {
+		return 0, false
+	}
+
+	return waitTimeSeconds, true
+}
+
+// messageErrorCode - maps errors of message operations to SQS error codes.
+func messageErrorCode(err error) (code string, senderFault bool) {
+	switch err {
+	case models.ErrReceiptHandleIsInvalid:
+		return "ReceiptHandleIsInvalid", true
+	case models.ErrMessageNotInflight:
+		return "AWS.SimpleQueueService.MessageNotInflight", true
+	case models.ErrQueueFull:
+		return "OverLimit", true
+	case models.ErrMissingMessageGroupID:
+		return "MissingParameter", true
+	case models.ErrMissingDeduplicationID, models.ErrInvalidMessageGroupID, models.ErrInvalidDeduplicationID, models.ErrInvalidParameterForStandard:
+		return "InvalidParameterValue", true
+	default:
+		return "InternalError", false
+	}
+}
+
+func writeMessageErrorResponse(c *gin.Context, err error) {
+	code, senderFault := messageErrorCode(err)
+	if !senderFault {
+		writeErrorResponse(c, cmd.ErrInternalError)
+		return
+	}
+
+	writeSenderErrorResponse(c, code, err.Error())
+}
+
+func newBatchResultErrorEntry(id string, err error) BatchResultErrorEntry {
+	code, senderFault := messageErrorCode(err)
+	return BatchResultErrorEntry{
+		ID:          id,
+		Code:        code,
+		Message:     err.Error(),
+		SenderFault: senderFault,
+	}
+}
+
+// parseQueueURL - returns the account ID and queue name addressed by the
+// request, either in the path or in the QueueUrl parameter.
+func parseQueueURL(c *gin.Context) (accountID string, queueName string) {
+	if c.Param("queue_name") != "" {
+		return c.Param("account_id"), c.Param("queue_name")
+	}
+
+	queueURL, err := url.Parse(formValue(c, "QueueUrl"))
+	if err != nil {
+		return
+	}
+
+	segments := strings.Split(queueURL.Path, "/")
+	if len(segments) < 3 {
+		return
+	}
+
+	return segments[1], segments[2]
+}
+
+// getQueue - authenticates the request and returns the queue it addresses.
+// An error response is written when it returns false.
+func getQueue(c *gin.Context) (queue models.Resource, ok bool) {
+	userID, errCode := authenticate(c.Request)
+	if errCode != cmd.ErrNone {
+		writeErrorResponse(c, errCode)
+		return
+	}
+
+	// The user sending messages is their sender
+	c.Set(senderIDKey, userID)
+
+	tokens := strings.Split(userID, ":")
+	if len(tokens) > 1 {
+		userID = tokens[0]
+	}
+
+	accountID, queueName := parseQueueURL(c)
+	if userID != accountID {
+		writeErrorResponse(c, cmd.ErrAccessDenied)
+		return
+	}
+
+	db := models.GetDB()
+	if db.Where(models.Resource{Service: models.SQS, AccountID: accountID, Name: queueName}).First(&queue).RecordNotFound() {
+		writeSenderErrorResponse(c, "AWS.SimpleQueueService.NonExistentQueue", "The specified queue does not exist for this wsdl version.")
+		return
+	}
+
+	return queue, true
+}
+
+// validateMessageBody - returns error code and message when body can not be
+// sent, or empty strings when it is valid.
+func validateMessageBody(body string, maxSize int) (code string, message string) {
+	if body == "" {
+		return "MissingParameter", "The request must contain the parameter MessageBody."
+	}
+
+	if len(body) > maxSize {
+		return "InvalidParameterValue", fmt.Sprintf("One or more parameters are invalid. Reason: Message must be shorter than %d bytes.", maxSize)
+	}
+
+	if !isValidMessageText(body) {
+		return "InvalidMessageContents", "Invalid binary character was found in the message body, the set of allowed characters is #x9 | #xA | #xD | #x20 to #xD7FF | #xE000 to #xFFFD | #x10000 to #x10FFFF"
+	}
+
+	return "", ""
+}
+
+// isValidMessageText - reports whether s only contains #x9 | #xA | #xD |
+// #x20 to #xD7FF | #xE000 to #xFFFD | #x10000 to #x10FFFF.
+func isValidMessageText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+
+	for _, r := range s {
+		switch {
+		case r == 0x9 || r == 0xA || r == 0xD:
+		case r >= 0x20 && r <= 0xD7FF:
+		case r >= 0xE000 && r <= 0xFFFD:
+		case r >= 0x10000 && r <= 0x10FFFF:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseMessageAttributes - returns the attributes in `<prefix>.N` parameters
+// such as `MessageAttribute.N`, or error code and message when they are
+// invalid.
+func parseMessageAttributes(values url.Values, prefix string) (attributes map[string]models.MessageAttribute, code string, message string) {
+	entries := parseEntries(values, prefix)
+	if len(entries) > maxMessageAttributes {
+		return nil, "InvalidParameterValue", fmt.Sprintf("Number of message attributes [%d] exceeds the allowed maximum [%d].", len(entries), maxMessageAttributes)
+	}
+
+	attributes = map[string]models.MessageAttribute{}
+	for _, entry := range entries {
+		name := entry.Get("Name")
+		lowerName := strings.ToLower(name)
+		if len(name) > 256 || !attributeNameRegexp.MatchString(name) || strings.HasPrefix(lowerName, "aws.") || strings.HasPrefix(lowerName, "amazon.") {
+			return nil, "InvalidParameterValue", fmt.Sprintf("Message (user) attribute name '%s' is invalid.", name)
+		}
+		if _, ok := attributes[name]; ok {
+			return nil, "InvalidParameterValue", fmt.Sprintf("Message (user) attribute name '%s' is repeated.", name)
+		}
+
+		attribute := models.MessageAttribute{DataType: entry.Get("Value.DataType")}
+		if len(attribute.DataType) > 256 || !attributeTypeRegexp.MatchString(attribute.DataType) {
+			return nil, "InvalidParameterValue", fmt.Sprintf("The type of message (user) attribute '%s' is invalid.", name)
+		}
+
+		if attribute.IsBinary() {
+			value, err := base64.StdEncoding.DecodeString(entry.Get("Value.BinaryValue"))
+			if err != nil || len(value) == 0 {
+				return nil, "InvalidParameterValue", fmt.Sprintf("Message (user) attribute '%s' must contain a non-empty value of type '%s'.", name, attribute.DataType)
+			}
+			attribute.BinaryValue = value
+		} else {
+			attribute.StringValue = entry.Get("Value.StringValue")
+			if attribute.StringValue == "" {
+				return nil, "InvalidParameterValue", fmt.Sprintf("Message (user) attribute '%s' must contain a non-empty value of type '%s'.", name, attribute.DataType)
+			}
+			if !isValidMessageText(attribute.StringValue) {
+				return nil, "InvalidParameterValue", fmt.Sprintf("Message (user) attribute '%s' contains invalid characters.", name)
+			}
+			if strings.HasPrefix(attribute.DataType, "Number") {
+				if _, err := strconv.ParseFloat(attribute.StringValue, 64); err != nil {
+					return nil, "InvalidParameterValue", fmt.Sprintf("Can't cast the value of message (user) attribute '%s' to a number.", name)
+				}
+			}
+		}
+
+		attributes[name] = attribute
+	}
+
+	return attributes, "", ""
+}
+
+// matchAttributeName - reports whether the attribute is requested by the
+// `AttributeName.N` or `MessageAttributeName.N` entries. `All` and `.*`
+// request all attributes, and `<prefix>.*` the ones with the prefix.
+func matchAttributeName(entries []url.Values, name string) bool {
+	for _, entry := range entries {
+		requested := entry.Get("")
+		switch {
+		case requested == "All" || requested == ".*" || requested == name:
+			return true
+		case strings.HasSuffix(requested, ".*") && strings.HasPrefix(name, strings.TrimSuffix(requested, "*")):
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedAttributeNames(attributes map[string]models.MessageAttribute) []string {
+	names := []string{}
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// newMessage - returns the message to be sent with the parameters, or error
+// code and message when they are invalid.
+func newMessage(c *gin.Context, queue models.Resource, values url.Values) (msg models.Message, code string, message string) {
+	body := values.Get("MessageBody")
+	if code, message := validateMessageBody(body, queue.MaximumMessageSize); code != "" {
+		return msg, code, message
+	}
+
+	attributes, code, message := parseMessageAttributes(values, "MessageAttribute")
+	if code != "" {
+		return msg, code, message
+	}
+
+	msg = models.NewMessage(body)
+	msg.MessageAttributes = attributes
+	msg.SenderID = c.GetString(senderIDKey)
+	msg.DelaySeconds = queue.DelaySeconds
+	if value := values.Get("DelaySeconds"); value != "" {
+		delaySeconds, err := strconv.Atoi(value)
+		if err != nil || delaySeconds < 0 || delaySeconds > maxDelaySeconds {
+			return msg, "InvalidParameterValue", fmt.Sprintf("Value %s for parameter DelaySeconds is invalid. Reason: DelaySeconds must be >= 0 and <= %d.", value, maxDelaySeconds)
+		}
+		// FIFO queues only support delays of the queue
+		if queue.FifoQueue {
+			return msg, "InvalidParameterValue", fmt.Sprintf("Value %s for parameter DelaySeconds is invalid. Reason: The request include parameter that is not valid for this queue type.", value)
+		}
+		msg.DelaySeconds = delaySeconds
+	}
+	if msg.Size() > queue.MaximumMessageSize {
+		return msg, "InvalidParameterValue", fmt.Sprintf("One or more parameters are invalid. Reason: Message must be shorter than %d bytes.", queue.MaximumMessageSize)
+	}
+
+	if err := queue.SetMessageGroup(&msg, values.Get("MessageGroupId"), values.Get("MessageDeduplicationId")); err != nil {
+		code, _ := messageErrorCode(err)
+		return msg, code, err.Error()
+	}
+
+	return msg, "", ""
+}
+
+// validateBatchEntries - returns error code and message when the entries of a
+// batch request can not be processed, or empty strings when they are valid.
+// The codes are prefixed with codePrefix, which is specific to the service.
+func validateBatchEntries(entries []url.Values, codePrefix string) (code string, message string) {
+	if len(entries) == 0 {
+		return codePrefix + "EmptyBatchRequest", "There should be at least one entry in the request."
+	}
+
+	if len(entries) > maxBatchEntries {
+		return codePrefix + "TooManyEntriesInBatchRequest", fmt.Sprintf("Maximum number of entries per request are %d. You have sent %d.", maxBatchEntries, len(entries))
+	}
+
+	ids := map[string]bool{}
+	for _, entry := range entries {
+		id := entry.Get("Id")
+		if !batchEntryIDRegexp.MatchString(id) {
+			return codePrefix + "InvalidBatchEntryId", "A batch entry id can only contain alphanumeric characters, hyphens and underscores. It can be at most 80 letters long."
+		}
+		if ids[id] {
+			return codePrefix + "BatchEntryIdsNotDistinct", fmt.Sprintf("Id %s repeated.", id)
+		}
+		ids[id] = true
+	}
+
+	return "", ""
+}
+
+func SendMessage(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	msg, code, message := newMessage(c, queue, formValues(c))
+	if code != "" {
+		writeSenderErrorResponse(c, code, message)
+		return
+	}
+
+	msgs, err := queue.SendMessages(msg)
+	if err != nil {
+		writeMessageErrorResponse(c, err)
+		return
+	}
+	msg = msgs[0]
+
+	requestID, _ := uuid.NewV4()
+	response := SendMessageResponse{
+		MD5OfMessageBody:       msg.MD5OfBody(),
+		MD5OfMessageAttributes: msg.MD5OfMessageAttributes(),
+		MessageID:              msg.ID,
+		SequenceNumber:         msg.SequenceNumber,
+		RequestID:              requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+func SendMessageBatch(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	entries := parseEntries(formValues(c), "SendMessageBatchRequestEntry")
+	if code, message := validateBatchEntries(entries, sqsCodePrefix); code != "" {
+		writeSenderErrorResponse(c, code, message)
+		return
+	}
+
+	response := SendMessageBatchResponse{
+		Successful: []SendMessageBatchResultEntry{},
+		Failed:     []BatchResultErrorEntry{},
+	}
+
+	ids := []string{}
+	msgs := []models.Message{}
+	totalSize := 0
+	for _, entry := range entries {
+		msg, code, message := newMessage(c, queue, entry)
+		totalSize += msg.Size()
+		if code != "" {
+			response.Failed = append(response.Failed, BatchResultErrorEntry{
+				ID:          entry.Get("Id"),
+				Code:        code,
+				Message:     message,
+				SenderFault: true,
+			})
+			continue
+		}
+
+		ids = append(ids, entry.Get("Id"))
+		msgs = append(msgs, msg)
+	}
+
+	if totalSize > maxMessageSize {
+		writeSenderErrorResponse(c, "AWS.SimpleQueueService.BatchRequestTooLong", fmt.Sprintf("Batch requests cannot be longer than %d bytes. You have sent %d bytes.", maxMessageSize, totalSize))
+		return
+	}
+
+	// Messages sent before an error are still successful
+	sent, err := queue.SendMessages(msgs...)
+	for i, msg := range sent {
+		response.Successful = append(response.Successful, SendMessageBatchResultEntry{
+			ID:                     ids[i],
+			MessageID:              msg.ID,
+			MD5OfMessageBody:       msg.MD5OfBody(),
+			MD5OfMessageAttributes: msg.MD5OfMessageAttributes(),
+			SequenceNumber:         msg.SequenceNumber,
+		})
+	}
+	if err != nil {
+		for _, id := range ids[len(sent):] {
+			response.Failed = append(response.Failed, newBatchResultErrorEntry(id, err))
+		}
+	}
+
+	requestID, _ := uuid.NewV4()
+	response.RequestID = requestID.String()
+	c.XML(http.StatusOK, response)
+}
+
+func writeAttributeErrorResponse(c *gin.Context, name string, err error) {
+	switch err {
+	case models.ErrInvalidAttributeName:
+		writeSenderErrorResponse(c, "InvalidAttributeName", fmt.Sprintf("Unknown Attribute %s.", name))
+	default:
+		writeSenderErrorResponse(c, "InvalidAttributeValue", fmt.Sprintf("Invalid value for the parameter %s.", name))
+	}
+}
+
+func GetQueueAttributes(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	attributes, err := queue.Attributes()
+	if err != nil {
+		writeErrorResponse(c, cmd.ErrInternalError)
+		return
+	}
+
+	names := []string{}
+	for _, entry := range parseEntries(formValues(c), "AttributeName") {
+		name := entry.Get("")
+		if name == "All" {
+			names = models.QueueAttributeNames
+			break
+		}
+		if !models.IsQueueAttributeName(name) {
+			writeAttributeErrorResponse(c, name, models.ErrInvalidAttributeName)
+			return
+		}
+		names = append(names, name)
+	}
+
+	response := GetQueueAttributesResponse{
+		Attributes: []Attribute{},
+	}
+	for _, name := range names {
+		// Attributes like RedrivePolicy are only returned when they are set
+		if _, ok := attributes[name]; !ok {
+			continue
+		}
+		response.Attributes = append(response.Attributes, Attribute{
+			Name:  name,
+			Value: attributes[name],
+		})
+	}
+
+	requestID, _ := uuid.NewV4()
+	response.RequestID = requestID.String()
+	c.XML(http.StatusOK, response)
+}
+
+func SetQueueAttributes(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	for _, attribute := range parseEntries(formValues(c), "Attribute") {
+		name := attribute.Get("Name")
+		if err := queue.SetAttribute(name, attribute.Get("Value")); err != nil {
+			writeAttributeErrorResponse(c, name, err)
+			return
+		}
+	}
+
+	db := models.GetDB()
+	db.Save(&queue)
+
+	requestID, _ := uuid.NewV4()
+	response := SetQueueAttributesResponse{
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+func ListDeadLetterSourceQueues(c *gin.Context) {
+	queue, ok := getQueue(c)
+	if !ok {
+		return
+	}
+
+	queueUrls := []string{}
+	for _, source := range queue.DeadLetterSourceQueues() {
+		queueUrls = append(queueUrls, source.URL())
+	}
+
+	requestID, _ := uuid.NewV4()
+	response := ListDeadLetterSourceQueuesResponse{
+		QueueURLs: queueUrls,
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+// getQueueByARN - returns the queue of the account with the ARN.
+func getQueueByARN(accountID string, arn string) (queue models.Resource, ok bool) {
+	target, err := models.ParseARN(arn)
+	if err != nil || target.Service != models.SQS || target.AccountID != accountID {
+		return
+	}
+
+	db := models.GetDB()
+	if db.Where(models.Resource{Service: models.SQS, AccountID: target.AccountID, Name: target.Name}).First(&queue).RecordNotFound() {
+		return
+	}
+
+	return queue, true
+}
+
+// StartMessageMoveTask - moves the messages of a dead-letter queue back to the
+// queues they came from, or to DestinationArn when it is given. Messages are
+// moved before the response is written.
+func StartMessageMoveTask(c *gin.Context) {
+	accountID, errCode := authenticate(c.Request)
+	if errCode != cmd.ErrNone {
+		writeErrorResponse(c, errCode)
+		return
+	}
+
+	tokens := strings.Split(accountID, ":")
+	if len(tokens) > 1 {
+		accountID = tokens[0]
+	}
+
+	sourceARN := formValue(c, "SourceArn")
+	source, ok := getQueueByARN(accountID, sourceARN)
+	if !ok {
+		writeSenderErrorResponse(c, "ResourceNotFoundException", fmt.Sprintf("The resource that you specified for the SourceArn parameter doesn't exist: %s", sourceARN))
+		return
+	}
+
+	if source.FifoQueue {
+		writeSenderErrorResponse(c, "InvalidParameterValue", "Message move tasks are not supported for FIFO queues.")
+		return
+	}
+
+	if len(source.DeadLetterSourceQueues()) == 0 {
+		writeSenderErrorResponse(c, "InvalidParameterValue", "Source queue must be configured as a Dead Letter Queue.")
+		return
+	}
+
+	var destination *models.Resource
+	if destinationARN := formValue(c, "DestinationArn"); destinationARN != "" {
+		queue, ok := getQueueByARN(accountID, destinationARN)
+		if !ok {
+			writeSenderErrorResponse(c, "ResourceNotFoundException", fmt.Sprintf("The resource that you specified for the DestinationArn parameter doesn't exist: %s", destinationARN))
+			return
+		}
+		if queue.FifoQueue {
+			writeSenderErrorResponse(c, "InvalidParameterValue", "Message move tasks are not supported for FIFO queues.")
+			return
+		}
+		destination = &queue
+	}
+
+	if _, err := source.RedriveMessages(destination); err == models.ErrNonExistentQueue {
+		writeSenderErrorResponse(c, "AWS.SimpleQueueService.NonExistentQueue", err.Error())
+		return
+	} else if err != nil {
+		writeErrorResponse(c, cmd.ErrInternalError)
+		return
+	}
+
+	taskHandle, _ := uuid.NewV4()
+	requestID, _ := uuid.NewV4()
+	response := StartMessageMoveTaskResponse{
+		TaskHandle: taskHandle.String(),
+		RequestID:  requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
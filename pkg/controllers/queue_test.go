@@ -1,10 +1,12 @@
 package controllers_test
 
 import (
+	"encoding/xml"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	. "github.com/smartystreets/goconvey/convey"
@@ -20,6 +22,7 @@ func setup() {
 	config.SetServerConfig()
 	models.SetDB()
 	models.Migrate()
+	models.SetCache()
 }
 
 func teardown() {
@@ -36,12 +39,7 @@ func TestListQueues(t *testing.T) {
 		var queue models.Resource
 
 		for _, name := range []string{"gin", "kaoliang"} {
-			queue = models.Resource{
-				Service:   models.SQS,
-				AccountID: "tester",
-				Name:      name,
-			}
-
+			queue = models.NewQueue("tester", name)
 			db.Create(&queue)
 		}
 
@@ -83,11 +81,7 @@ func TestDeleteQueue(t *testing.T) {
 
 	Convey("Given a queue", t, func() {
 		db := models.GetDB()
-		queue := models.Resource{
-			Service:   models.SQS,
-			AccountID: "tester",
-			Name:      "kaoliang",
-		}
+		queue := models.NewQueue("tester", "kaoliang")
 		db.Create(&queue)
 
 		Convey("When access to delete queue controller", func() {
@@ -102,3 +96,214 @@ func TestDeleteQueue(t *testing.T) {
 		})
 	})
 }
+
+func TestSendMessage(t *testing.T) {
+	setup()
+	defer teardown()
+
+	Convey("Given a queue", t, func() {
+		db := models.GetDB()
+		queue := models.NewQueue("tester", "kaoliang")
+		db.Create(&queue)
+		defer queue.DeleteMessages()
+
+		Convey("When send a message to it", func() {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", "/?Action=SendMessage&QueueUrl=http://cloud.inwinstack.com/tester/kaoliang&MessageBody=foobar", nil)
+			controllers.SendMessage(c)
+
+			response := controllers.SendMessageResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &response)
+
+			Convey("The MD5 of message body should be returned", func() {
+				So(w.Code, ShouldEqual, 200)
+				So(response.MD5OfMessageBody, ShouldEqual, "3858f62230ac3c915f300c664312c63f")
+			})
+
+			Convey("The message should be pushed to the queue", func() {
+				msgs, _ := queue.ReceiveMessages(10, time.Minute)
+				So(msgs, ShouldHaveLength, 1)
+				So(msgs[0].ID, ShouldEqual, response.MessageID)
+				So(msgs[0].Body, ShouldEqual, "foobar")
+			})
+		})
+	})
+}
+
+// serve - sends the request to the controller and returns its response.
+func serve(controller gin.HandlerFunc, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", target, nil)
+	controller(c)
+
+	return w
+}
+
+func TestListQueuesFilters(t *testing.T) {
+	setup()
+	defer teardown()
+
+	Convey("Given some queues", t, func() {
+		db := models.GetDB()
+		queues := map[string]models.Resource{}
+		for _, name := range []string{"kaoliang", "kaoliang-dlq", "gin", "k_test"} {
+			queue := models.NewQueue("tester", name)
+			db.Create(&queue)
+			queues[name] = queue
+		}
+		other := models.NewQueue("someone", "kaoliang")
+		db.Create(&other)
+
+		Reset(teardown)
+
+		Convey("When list queues with a prefix", func() {
+			w := serve(controllers.ListQueues, "/?Action=ListQueues&QueueNamePrefix=kaoliang")
+			response := controllers.ListQueuesResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &response)
+
+			Convey("Only the queues of the account with the prefix should be returned", func() {
+				So(w.Code, ShouldEqual, 200)
+				So(response.QueueURLs, ShouldResemble, []string{queues["kaoliang"].URL(), queues["kaoliang-dlq"].URL()})
+				So(response.NextToken, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When list queues with a prefix containing wildcards", func() {
+			w := serve(controllers.ListQueues, "/?Action=ListQueues&QueueNamePrefix=k_")
+			response := controllers.ListQueuesResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &response)
+
+			Convey("The wildcards should match themselves", func() {
+				So(response.QueueURLs, ShouldResemble, []string{queues["k_test"].URL()})
+			})
+		})
+
+		Convey("When list queues page by page", func() {
+			w := serve(controllers.ListQueues, "/?Action=ListQueues&MaxResults=3")
+			first := controllers.ListQueuesResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &first)
+
+			w = serve(controllers.ListQueues, "/?Action=ListQueues&MaxResults=3&NextToken="+first.NextToken)
+			second := controllers.ListQueuesResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &second)
+
+			Convey("Each page should continue after the previous one", func() {
+				So(first.QueueURLs, ShouldResemble, []string{queues["kaoliang"].URL(), queues["kaoliang-dlq"].URL(), queues["gin"].URL()})
+				So(first.NextToken, ShouldNotBeEmpty)
+				So(second.QueueURLs, ShouldResemble, []string{queues["k_test"].URL()})
+				So(second.NextToken, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When list queues with invalid pagination", func() {
+			maxResults := serve(controllers.ListQueues, "/?Action=ListQueues&MaxResults=0")
+			maxResultsError := controllers.ErrorResponse{}
+			xml.Unmarshal(maxResults.Body.Bytes(), &maxResultsError)
+
+			nextToken := serve(controllers.ListQueues, "/?Action=ListQueues&NextToken=foobar")
+			nextTokenError := controllers.ErrorResponse{}
+			xml.Unmarshal(nextToken.Body.Bytes(), &nextTokenError)
+
+			Convey("The parameters should be rejected", func() {
+				So(maxResults.Code, ShouldEqual, 400)
+				So(maxResultsError.Code, ShouldEqual, "InvalidParameterValue")
+				So(nextToken.Code, ShouldEqual, 400)
+				So(nextTokenError.Code, ShouldEqual, "InvalidParameterValue")
+			})
+		})
+	})
+}
+
+func TestGetQueueURL(t *testing.T) {
+	setup()
+	defer teardown()
+
+	Convey("Given a queue", t, func() {
+		db := models.GetDB()
+		queue := models.NewQueue("tester", "kaoliang")
+		db.Create(&queue)
+
+		Reset(teardown)
+
+		Convey("When get the URL of the queue", func() {
+			w := serve(controllers.GetQueueURL, "/?Action=GetQueueUrl&QueueName=kaoliang")
+			response := controllers.GetQueueURLResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &response)
+
+			owned := serve(controllers.GetQueueURL, "/?Action=GetQueueUrl&QueueName=kaoliang&QueueOwnerAWSAccountId=tester")
+			ownedResponse := controllers.GetQueueURLResponse{}
+			xml.Unmarshal(owned.Body.Bytes(), &ownedResponse)
+
+			Convey("The URL of the queue should be returned", func() {
+				So(w.Code, ShouldEqual, 200)
+				So(response.QueueURL, ShouldEqual, queue.URL())
+				So(owned.Code, ShouldEqual, 200)
+				So(ownedResponse.QueueURL, ShouldEqual, queue.URL())
+			})
+		})
+
+		Convey("When get the URL of a queue of another account", func() {
+			w := serve(controllers.GetQueueURL, "/?Action=GetQueueUrl&QueueName=kaoliang&QueueOwnerAWSAccountId=someone")
+
+			Convey("The access should be denied", func() {
+				So(w.Code, ShouldEqual, 403)
+			})
+		})
+
+		Convey("When get the URL of a queue which does not exist", func() {
+			w := serve(controllers.GetQueueURL, "/?Action=GetQueueUrl&QueueName=foobar")
+			response := controllers.ErrorResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &response)
+
+			Convey("The queue should not be found", func() {
+				So(w.Code, ShouldEqual, 400)
+				So(response.Code, ShouldEqual, "AWS.SimpleQueueService.NonExistentQueue")
+			})
+		})
+	})
+}
+
+func TestPurgeQueue(t *testing.T) {
+	setup()
+	defer teardown()
+
+	Convey("Given a queue with visible and received messages", t, func() {
+		db := models.GetDB()
+		queue := models.NewQueue("tester", "purged")
+		db.Create(&queue)
+		So(queue.PurgeMessages(), ShouldBeNil)
+
+		_, err := queue.SendMessages(models.NewMessage("foo"), models.NewMessage("bar"))
+		So(err, ShouldBeNil)
+		received, err := queue.ReceiveMessages(1, time.Minute)
+		So(err, ShouldBeNil)
+		So(received, ShouldHaveLength, 1)
+
+		Reset(teardown)
+
+		Convey("When purge the queue", func() {
+			w := serve(controllers.PurgeQueue, "/?Action=PurgeQueue&QueueUrl="+queue.URL())
+			attributes, _ := queue.Attributes()
+
+			Convey("All messages should be deleted", func() {
+				So(w.Code, ShouldEqual, 200)
+				So(attributes["ApproximateNumberOfMessages"], ShouldEqual, "0")
+				So(attributes["ApproximateNumberOfMessagesNotVisible"], ShouldEqual, "0")
+				So(queue.ChangeMessageVisibility(received[0].ReceiptHandle, 0), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When purge a queue which does not exist", func() {
+			w := serve(controllers.PurgeQueue, "/?Action=PurgeQueue&QueueUrl=http://cloud.inwinstack.com/tester/foobar")
+			response := controllers.ErrorResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &response)
+
+			Convey("The queue should not be found", func() {
+				So(w.Code, ShouldEqual, 400)
+				So(response.Code, ShouldEqual, "AWS.SimpleQueueService.NonExistentQueue")
+			})
+		})
+	})
+}
@@ -16,14 +16,28 @@ package controllers
 
 import (
 	"encoding/xml"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio/cmd"
+	"github.com/satori/go.uuid"
 )
 
 type ListQueuesResponse struct {
 	XMLName   xml.Name `xml:"ListQueuesResponse"`
 	QueueURLs []string `xml:"ListQueuesResult>QueueUrl"`
+	NextToken string   `xml:"ListQueuesResult>NextToken,omitempty"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type GetQueueURLResponse struct {
+	XMLName   xml.Name `xml:"GetQueueUrlResponse"`
+	QueueURL  string   `xml:"GetQueueUrlResult>QueueUrl"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type PurgeQueueResponse struct {
+	XMLName   xml.Name `xml:"PurgeQueueResponse"`
 	RequestID string   `xml:"ResponseMetadata>RequestId"`
 }
 
@@ -45,11 +59,112 @@ type ReceiveMessageResponse struct {
 }
 
 type Message struct {
-	XMLName       xml.Name `xml:"Message"`
-	MessageID     string   `xml:"MessageId"`
-	ReceiptHandle string   `xml:"ReceiptHandle"`
-	MD5OfBody     string   `xml:"MD5OfBody"`
-	Body          string   `xml:"Body"`
+	XMLName                xml.Name           `xml:"Message"`
+	MessageID              string             `xml:"MessageId"`
+	ReceiptHandle          string             `xml:"ReceiptHandle"`
+	MD5OfBody              string             `xml:"MD5OfBody"`
+	Body                   string             `xml:"Body"`
+	Attributes             []Attribute        `xml:"Attribute"`
+	MD5OfMessageAttributes string             `xml:"MD5OfMessageAttributes,omitempty"`
+	MessageAttributes      []MessageAttribute `xml:"MessageAttribute"`
+}
+
+type MessageAttributeValue struct {
+	StringValue string `xml:"StringValue,omitempty"`
+	BinaryValue string `xml:"BinaryValue,omitempty"`
+	DataType    string `xml:"DataType"`
+}
+
+type MessageAttribute struct {
+	Name  string                `xml:"Name"`
+	Value MessageAttributeValue `xml:"Value"`
+}
+
+type SendMessageResponse struct {
+	XMLName                xml.Name `xml:"SendMessageResponse"`
+	MD5OfMessageBody       string   `xml:"SendMessageResult>MD5OfMessageBody"`
+	MD5OfMessageAttributes string   `xml:"SendMessageResult>MD5OfMessageAttributes,omitempty"`
+	MessageID              string   `xml:"SendMessageResult>MessageId"`
+	SequenceNumber         string   `xml:"SendMessageResult>SequenceNumber,omitempty"`
+	RequestID              string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type SendMessageBatchResultEntry struct {
+	ID                     string `xml:"Id"`
+	MessageID              string `xml:"MessageId"`
+	MD5OfMessageBody       string `xml:"MD5OfMessageBody"`
+	MD5OfMessageAttributes string `xml:"MD5OfMessageAttributes,omitempty"`
+	SequenceNumber         string `xml:"SequenceNumber,omitempty"`
+}
+
+type BatchResultErrorEntry struct {
+	ID          string `xml:"Id"`
+	Code        string `xml:"Code"`
+	Message     string `xml:"Message"`
+	SenderFault bool   `xml:"SenderFault"`
+}
+
+type SendMessageBatchResponse struct {
+	XMLName    xml.Name                      `xml:"SendMessageBatchResponse"`
+	Successful []SendMessageBatchResultEntry `xml:"SendMessageBatchResult>SendMessageBatchResultEntry"`
+	Failed     []BatchResultErrorEntry       `xml:"SendMessageBatchResult>BatchResultErrorEntry"`
+	RequestID  string                        `xml:"ResponseMetadata>RequestId"`
+}
+
+type BatchResultEntry struct {
+	ID string `xml:"Id"`
+}
+
+type DeleteMessageResponse struct {
+	XMLName   xml.Name `xml:"DeleteMessageResponse"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type DeleteMessageBatchResponse struct {
+	XMLName    xml.Name                `xml:"DeleteMessageBatchResponse"`
+	Successful []BatchResultEntry      `xml:"DeleteMessageBatchResult>DeleteMessageBatchResultEntry"`
+	Failed     []BatchResultErrorEntry `xml:"DeleteMessageBatchResult>BatchResultErrorEntry"`
+	RequestID  string                  `xml:"ResponseMetadata>RequestId"`
+}
+
+type ChangeMessageVisibilityResponse struct {
+	XMLName   xml.Name `xml:"ChangeMessageVisibilityResponse"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type ChangeMessageVisibilityBatchResponse struct {
+	XMLName    xml.Name                `xml:"ChangeMessageVisibilityBatchResponse"`
+	Successful []BatchResultEntry      `xml:"ChangeMessageVisibilityBatchResult>ChangeMessageVisibilityBatchResultEntry"`
+	Failed     []BatchResultErrorEntry `xml:"ChangeMessageVisibilityBatchResult>BatchResultErrorEntry"`
+	RequestID  string                  `xml:"ResponseMetadata>RequestId"`
+}
+
+type Attribute struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+type GetQueueAttributesResponse struct {
+	XMLName    xml.Name    `xml:"GetQueueAttributesResponse"`
+	Attributes []Attribute `xml:"GetQueueAttributesResult>Attribute"`
+	RequestID  string      `xml:"ResponseMetadata>RequestId"`
+}
+
+type SetQueueAttributesResponse struct {
+	XMLName   xml.Name `xml:"SetQueueAttributesResponse"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type ListDeadLetterSourceQueuesResponse struct {
+	XMLName   xml.Name `xml:"ListDeadLetterSourceQueuesResponse"`
+	QueueURLs []string `xml:"ListDeadLetterSourceQueuesResult>QueueUrl"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type StartMessageMoveTaskResponse struct {
+	XMLName    xml.Name `xml:"StartMessageMoveTaskResponse"`
+	TaskHandle string   `xml:"StartMessageMoveTaskResult>TaskHandle"`
+	RequestID  string   `xml:"ResponseMetadata>RequestId"`
 }
 
 type ErrorResponse struct {
@@ -73,6 +188,7 @@ type TopicARN struct {
 type ListTopicsResponse struct {
 	XMLName   xml.Name   `xml:"ListTopicsResponse"`
 	TopicARNs []TopicARN `xml:"ListTopicsResult>Topics>member"`
+	NextToken string     `xml:"ListTopicsResult>NextToken,omitempty"`
 	RequestID string     `xml:"ResponseMetadata>RequestId"`
 }
 
@@ -87,6 +203,12 @@ type SubscribeResponse struct {
 	RequestID       string   `xml:"ResponseMetadata>RequestId"`
 }
 
+type ConfirmSubscriptionResponse struct {
+	XMLName         xml.Name `xml:"ConfirmSubscriptionResponse"`
+	SubscriptionARN string   `xml:"ConfirmSubscriptionResult>SubscriptionArn"`
+	RequestID       string   `xml:"ResponseMetadata>RequestId"`
+}
+
 type SubscriptionARN struct {
 	TopicARN string `xml:"TopicArn"`
 	Protocol string `xml:"Protocol"`
@@ -98,6 +220,14 @@ type SubscriptionARN struct {
 type ListSubscriptionsResponse struct {
 	XMLName          xml.Name          `xml:"ListSubscriptionsResponse"`
 	SubscriptionARNs []SubscriptionARN `xml:"ListSubscriptionsResult>Subscriptions>member"`
+	NextToken        string            `xml:"ListSubscriptionsResult>NextToken,omitempty"`
+	RequestID        string            `xml:"ResponseMetadata>RequestId"`
+}
+
+type ListSubscriptionsByTopicResponse struct {
+	XMLName          xml.Name          `xml:"ListSubscriptionsByTopicResponse"`
+	SubscriptionARNs []SubscriptionARN `xml:"ListSubscriptionsByTopicResult>Subscriptions>member"`
+	NextToken        string            `xml:"ListSubscriptionsByTopicResult>NextToken,omitempty"`
 	RequestID        string            `xml:"ResponseMetadata>RequestId"`
 }
 
@@ -106,8 +236,64 @@ type UnsubscribeResponse struct {
 	RequestID string   `xml:"ResponseMetadata>RequestId"`
 }
 
+type PublishResponse struct {
+	XMLName   xml.Name `xml:"PublishResponse"`
+	MessageID string   `xml:"PublishResult>MessageId"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type PublishBatchResultEntry struct {
+	ID        string `xml:"Id"`
+	MessageID string `xml:"MessageId"`
+}
+
+type PublishBatchResponse struct {
+	XMLName    xml.Name                  `xml:"PublishBatchResponse"`
+	Successful []PublishBatchResultEntry `xml:"PublishBatchResult>Successful>member"`
+	Failed     []BatchResultErrorEntry   `xml:"PublishBatchResult>Failed>member"`
+	RequestID  string                    `xml:"ResponseMetadata>RequestId"`
+}
+
+type AttributeEntry struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+type GetTopicAttributesResponse struct {
+	XMLName    xml.Name         `xml:"GetTopicAttributesResponse"`
+	Attributes []AttributeEntry `xml:"GetTopicAttributesResult>Attributes>entry"`
+	RequestID  string           `xml:"ResponseMetadata>RequestId"`
+}
+
+type SetTopicAttributesResponse struct {
+	XMLName   xml.Name `xml:"SetTopicAttributesResponse"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
+type GetSubscriptionAttributesResponse struct {
+	XMLName    xml.Name         `xml:"GetSubscriptionAttributesResponse"`
+	Attributes []AttributeEntry `xml:"GetSubscriptionAttributesResult>Attributes>entry"`
+	RequestID  string           `xml:"ResponseMetadata>RequestId"`
+}
+
+type SetSubscriptionAttributesResponse struct {
+	XMLName   xml.Name `xml:"SetSubscriptionAttributesResponse"`
+	RequestID string   `xml:"ResponseMetadata>RequestId"`
+}
+
 func writeErrorResponse(c *gin.Context, errorCode cmd.APIErrorCode) {
 	apiError := cmd.GetAPIError(errorCode)
 	errorResponse := cmd.GetAPIErrorResponse(apiError, c.Request.URL.Path)
 	c.XML(apiError.HTTPStatusCode, errorResponse)
 }
+
+func writeSenderErrorResponse(c *gin.Context, code, message string) {
+	requestID, _ := uuid.NewV4()
+	body := ErrorResponse{
+		Type:      "Sender",
+		Code:      code,
+		Message:   message,
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusBadRequest, body)
+}
@@ -15,8 +15,11 @@ Licensed under the Apache License, Version 2.0 (the "License");
 package controllers
 
 import (
+	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -26,6 +29,13 @@ import (
 	"github.com/inwinstack/kaoliang/pkg/models"
 )
 
+const (
+	listTopicsPageSize        = 100
+	listSubscriptionsPageSize = 100
+)
+
+var subjectRegexp = regexp.MustCompile("^[ -~]{1,100}$")
+
 func CreateTopic(c *gin.Context) {
 	accountID, errCode := authenticate(c.Request)
 	if errCode != cmd.ErrNone {
@@ -54,7 +64,19 @@ func CreateTopic(c *gin.Context) {
 		return
 	}
 
-	topic := models.Resource{}
+	// Attributes only apply to new topics, but are validated anyway
+	topic := models.Resource{
+		Service:   models.SNS,
+		AccountID: accountID,
+		Name:      topicName,
+	}
+	for name, value := range parseAttributeEntries(formValues(c)) {
+		if err := topic.SetTopicAttribute(name, value); err != nil {
+			writeTopicAttributeErrorResponse(c, name, err)
+			return
+		}
+	}
+
 	db.Where(models.Resource{
 		Service:   models.SNS,
 		AccountID: accountID,
@@ -80,12 +102,23 @@ func ListTopics(c *gin.Context) {
 		accountID = tokens[0]
 	}
 
+	afterID, ok := parseNextToken(c)
+	if !ok {
+		return
+	}
+
 	db := models.GetDB()
 	topics := []models.Resource{}
 	db.Where(&models.Resource{
 		Service:   models.SNS,
 		AccountID: accountID,
-	}).Find(&topics)
+	}).Where("id > ?", afterID).Order("id").Limit(listTopicsPageSize + 1).Find(&topics)
+
+	var nextToken string
+	if len(topics) > listTopicsPageSize {
+		topics = topics[:listTopicsPageSize]
+		nextToken = encodeNextToken(topics[listTopicsPageSize-1].ID)
+	}
 
 	topicARNs := []TopicARN{}
 	for _, topic := range topics {
@@ -95,6 +128,7 @@ func ListTopics(c *gin.Context) {
 	requestID, _ := uuid.NewV4()
 	body := ListTopicsResponse{
 		TopicARNs: topicARNs,
+		NextToken: nextToken,
 		RequestID: requestID.String(),
 	}
 
@@ -141,37 +175,123 @@ func DeleteTopic(c *gin.Context) {
 }
 
 func Subscribe(c *gin.Context) {
-	accountID, errCode := authenticate(c.Request)
-	if errCode != cmd.ErrNone {
-		writeErrorResponse(c, errCode)
+	topic, ok := getTopic(c, c.PostForm("TopicArn"))
+	if !ok {
 		return
 	}
 
-	tokens := strings.Split(accountID, ":")
-	if len(tokens) > 1 {
-		accountID = tokens[0]
-	}
-
 	endpointURI := c.PostForm("Endpoint")
 	protocol := c.PostForm("Protocol")
-	topicARN := c.PostForm("TopicArn")
-	targetTopic, _ := models.ParseARN(topicARN)
-	targetTopic.AccountID = accountID
+	switch protocol {
+	case "http", "https":
+		if u, err := url.Parse(endpointURI); err != nil || u.Scheme != protocol || u.Host == "" {
+			writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: Endpoint must match the specified protocol")
+			return
+		}
+	case "sqs":
+		if !validateQueueEndpoint(c, topic, endpointURI) {
+			return
+		}
+	default:
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: Amazon SNS does not support this protocol string: "+protocol)
+		return
+	}
 
 	db := models.GetDB()
-	topic := models.Resource{}
-	db.Where(targetTopic).First(&topic)
+	endpoint := models.Endpoint{}
+	if db.Where(models.Endpoint{ResourceID: topic.ID, Protocol: protocol, URI: endpointURI}).First(&endpoint).RecordNotFound() {
+		endpoint = models.NewEndpoint(protocol, endpointURI)
+		endpoint.ResourceID = topic.ID
+		if name, err := endpoint.SetAttributes(topic, parseAttributeEntries(formValues(c))); err != nil {
+			writeTopicAttributeErrorResponse(c, name, err)
+			return
+		}
+		db.Create(&endpoint)
+	} else if endpoint.PendingConfirmation {
+		// Subscribing again sends a new confirmation
+		endpoint.ResetToken()
+		db.Save(&endpoint)
+	}
 
-	endpointID, _ := uuid.NewV4()
-	db.Model(&topic).Association("Endpoints").Append(models.Endpoint{
-		Protocol: protocol,
-		URI:      endpointURI,
-		Name:     endpointID.String(),
-	})
+	if endpoint.PendingConfirmation {
+		if err := endpoint.SendConfirmation(topic); err != nil {
+			writeErrorResponse(c, cmd.ErrInternalError)
+			return
+		}
+	}
 
 	requestID, _ := uuid.NewV4()
 	body := SubscribeResponse{
-		SubscriptionARN: topic.ARN() + ":" + endpointID.String(),
+		SubscriptionARN: endpoint.ARN(topic),
+		RequestID:       requestID.String(),
+	}
+
+	c.XML(http.StatusOK, body)
+}
+
+// validateQueueEndpoint - checks that the endpoint is the ARN of a standard
+// queue the topic can send messages to, queues of other accounts must permit
+// it by their policy. An error response is written when it returns false.
+func validateQueueEndpoint(c *gin.Context, topic models.Resource, endpointURI string) bool {
+	target, err := models.ParseARN(endpointURI)
+	if err != nil || target.Service != models.SQS {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: SQS endpoint ARN")
+		return false
+	}
+
+	db := models.GetDB()
+	queue := models.Resource{}
+	if db.Where(models.Resource{Service: models.SQS, AccountID: target.AccountID, Name: target.Name}).First(&queue).RecordNotFound() {
+		writeSenderErrorResponse(c, "NotFound", "Endpoint does not exist")
+		return false
+	}
+
+	if queue.FifoQueue {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: Endpoint Reason: FIFO SQS Queues can not be subscribed to standard SNS topics")
+		return false
+	}
+
+	if !queue.AllowsSendFrom(topic) {
+		writeErrorResponse(c, cmd.ErrAuthorizationError)
+		return false
+	}
+
+	return true
+}
+
+// ConfirmSubscription - confirms a subscription with the token sent to its
+// endpoint. The request is not authenticated since the owner of the endpoint
+// visits SubscribeURL, the token proves the endpoint received it.
+func ConfirmSubscription(c *gin.Context) {
+	token := formValue(c, "Token")
+	targetTopic, err := models.ParseARN(formValue(c, "TopicArn"))
+	if err != nil || targetTopic.Service != models.SNS {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: TopicArn")
+		return
+	}
+
+	db := models.GetDB()
+	topic := models.Resource{}
+	if db.Where(models.Resource{Service: models.SNS, AccountID: targetTopic.AccountID, Name: targetTopic.Name}).First(&topic).RecordNotFound() {
+		writeSenderErrorResponse(c, "NotFound", "Topic does not exist")
+		return
+	}
+
+	endpoint := models.Endpoint{}
+	if token == "" || db.Where(models.Endpoint{ResourceID: topic.ID, Token: token}).First(&endpoint).RecordNotFound() {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: Token")
+		return
+	}
+
+	if err := endpoint.Confirm(token); err != nil {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: "+err.Error())
+		return
+	}
+	db.Save(&endpoint)
+
+	requestID, _ := uuid.NewV4()
+	body := ConfirmSubscriptionResponse{
+		SubscriptionARN: endpoint.ARN(topic),
 		RequestID:       requestID.String(),
 	}
 
@@ -190,35 +310,92 @@ func ListSubscriptions(c *gin.Context) {
 		accountID = tokens[0]
 	}
 
-	topics := []models.Resource{}
-	db := models.GetDB()
-	db.Where(models.Resource{AccountID: accountID}).Find(&topics)
-
-	subscriptionARNs := []SubscriptionARN{}
-	for _, topic := range topics {
-		endpoints := []models.Endpoint{}
-		db.Model(&topic).Association("Endpoints").Find(&endpoints)
-		if len(endpoints) > 0 {
-			for _, endpoint := range endpoints {
-				subscriptionARNs = append(subscriptionARNs, SubscriptionARN{
-					TopicARN: topic.ARN(),
-					Protocol: endpoint.Protocol,
-					ARN:      topic.ARN() + ":" + endpoint.Name,
-					Owner:    topic.AccountID,
-					Endpoint: endpoint.URI,
-				})
-			}
-		}
+	subscriptions, nextToken, ok := listSubscriptions(c, accountID, 0)
+	if !ok {
+		return
 	}
 
 	requestID, _ := uuid.NewV4()
 	body := ListSubscriptionsResponse{
-		SubscriptionARNs: subscriptionARNs,
+		SubscriptionARNs: subscriptions,
+		NextToken:        nextToken,
+		RequestID:        requestID.String(),
+	}
+	c.XML(http.StatusOK, body)
+}
+
+func ListSubscriptionsByTopic(c *gin.Context) {
+	topic, ok := getTopic(c, c.PostForm("TopicArn"))
+	if !ok {
+		return
+	}
+
+	subscriptions, nextToken, ok := listSubscriptions(c, topic.AccountID, topic.ID)
+	if !ok {
+		return
+	}
+
+	requestID, _ := uuid.NewV4()
+	body := ListSubscriptionsByTopicResponse{
+		SubscriptionARNs: subscriptions,
+		NextToken:        nextToken,
 		RequestID:        requestID.String(),
 	}
 	c.XML(http.StatusOK, body)
 }
 
+// listSubscriptions - returns a page of the subscriptions to the topics of
+// the account, or to the topic when topicID is not 0, and the token of the
+// next page.
+func listSubscriptions(c *gin.Context, accountID string, topicID uint) (subscriptionARNs []SubscriptionARN, nextToken string, ok bool) {
+	afterID, ok := parseNextToken(c)
+	if !ok {
+		return
+	}
+
+	subscriptions, err := models.ListSubscriptions(accountID, topicID, afterID, listSubscriptionsPageSize+1)
+	if err != nil {
+		writeErrorResponse(c, cmd.ErrInternalError)
+		return nil, "", false
+	}
+
+	if len(subscriptions) > listSubscriptionsPageSize {
+		subscriptions = subscriptions[:listSubscriptionsPageSize]
+		nextToken = encodeNextToken(subscriptions[listSubscriptionsPageSize-1].ID)
+	}
+
+	subscriptionARNs = []SubscriptionARN{}
+	for _, subscription := range subscriptions {
+		topic := subscription.Topic()
+		subscriptionARNs = append(subscriptionARNs, SubscriptionARN{
+			TopicARN: topic.ARN(),
+			Protocol: subscription.Protocol,
+			ARN:      subscription.ARN(topic),
+			Owner:    topic.AccountID,
+			Endpoint: subscription.URI,
+		})
+	}
+
+	return subscriptionARNs, nextToken, true
+}
+
+// parseNextToken - returns the ID of the last record of the previous page,
+// which is 0 for the first page.
+func parseNextToken(c *gin.Context) (uint, bool) {
+	token := formValue(c, "NextToken")
+	if token == "" {
+		return 0, true
+	}
+
+	id, ok := decodeNextToken(token)
+	if !ok {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: NextToken")
+		return 0, false
+	}
+
+	return id, true
+}
+
 func Unsubscribe(c *gin.Context) {
 	accountID, errCode := authenticate(c.Request)
 	if errCode != cmd.ErrNone {
@@ -262,3 +439,332 @@ func Unsubscribe(c *gin.Context) {
 
 	c.XML(http.StatusOK, body)
 }
+
+// UnsubscribeByURL - removes the subscription by the UnsubscribeURL of its
+// notifications. Like confirmations it is not authenticated, the token of the
+// subscription in the URL proves the request comes from the subscriber.
+func UnsubscribeByURL(c *gin.Context) {
+	token := c.Query("Token")
+	subscriptionARN := c.Query("SubscriptionArn")
+	targetTopic, err := models.ParseARN(subscriptionARN)
+	targetSubscription, subscriptionErr := models.ParseSubscription(subscriptionARN)
+	if err != nil || subscriptionErr != nil || targetTopic.Service != models.SNS {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: SubscriptionArn")
+		return
+	}
+
+	db := models.GetDB()
+	topic := models.Resource{}
+	subscription := models.Endpoint{}
+	if db.Where(models.Resource{Service: models.SNS, AccountID: targetTopic.AccountID, Name: targetTopic.Name}).First(&topic).RecordNotFound() ||
+		db.Where(models.Endpoint{ResourceID: topic.ID, Name: targetSubscription.Name}).First(&subscription).RecordNotFound() {
+		writeSenderErrorResponse(c, "NotFound", "Subscription does not exist")
+		return
+	}
+
+	if token == "" || subscription.PendingConfirmation || token != subscription.Token {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: Token")
+		return
+	}
+
+	db.Delete(&subscription)
+
+	requestID, _ := uuid.NewV4()
+	body := UnsubscribeResponse{
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, body)
+}
+
+// getTopic - returns the topic of the ARN, which must be owned by the user.
+// Its endpoints are not loaded.
+func getTopic(c *gin.Context, topicARN string) (topic models.Resource, ok bool) {
+	userID, errCode := authenticate(c.Request)
+	if errCode != cmd.ErrNone {
+		writeErrorResponse(c, errCode)
+		return
+	}
+
+	tokens := strings.Split(userID, ":")
+	if len(tokens) > 1 {
+		userID = tokens[0]
+	}
+
+	targetTopic, err := models.ParseARN(topicARN)
+	if err != nil || targetTopic.Service != models.SNS {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: TopicArn")
+		return
+	}
+
+	if userID != targetTopic.AccountID {
+		writeErrorResponse(c, cmd.ErrAuthorizationError)
+		return
+	}
+
+	db := models.GetDB()
+	if db.Where(models.Resource{Service: models.SNS, AccountID: targetTopic.AccountID, Name: targetTopic.Name}).First(&topic).RecordNotFound() {
+		writeSenderErrorResponse(c, "NotFound", "Topic does not exist")
+		return
+	}
+
+	return topic, true
+}
+
+// newNotification - returns the notification to be published with the
+// parameters, or error code and message when they are invalid.
+func newNotification(topic models.Resource, values url.Values) (n models.Notification, code string, message string) {
+	msg := values.Get("Message")
+	if msg == "" {
+		return n, "InvalidParameter", "Invalid parameter: Empty message"
+	}
+
+	if !isValidMessageText(msg) {
+		return n, "InvalidParameter", "Invalid parameter: Message contains invalid characters"
+	}
+
+	subject, hasSubject := values["Subject"]
+	if hasSubject && !subjectRegexp.MatchString(subject[0]) {
+		return n, "InvalidParameter", "Invalid parameter: Subject"
+	}
+
+	attributes, code, message := parseMessageAttributes(values, "MessageAttributes.entry")
+	if code != "" {
+		return n, "InvalidParameterValue", message
+	}
+
+	n = models.NewNotification(topic, msg)
+	n.Subject = values.Get("Subject")
+	n.MessageAttributes = attributes
+	if err := n.SetMessageStructure(values.Get("MessageStructure")); err != nil {
+		return n, "InvalidParameter", err.Error()
+	}
+	if n.Size() > maxMessageSize {
+		return n, "InvalidParameter", "Invalid parameter: Message too long"
+	}
+
+	return n, "", ""
+}
+
+func Publish(c *gin.Context) {
+	values := formValues(c)
+	topic, ok := getTopic(c, values.Get("TopicArn"))
+	if !ok {
+		return
+	}
+	models.GetDB().Model(&topic).Related(&topic.Endpoints)
+
+	n, code, message := newNotification(topic, values)
+	if code != "" {
+		writeSenderErrorResponse(c, code, message)
+		return
+	}
+
+	if err := topic.Publish(n); err != nil {
+		writeErrorResponse(c, cmd.ErrInternalError)
+		return
+	}
+
+	requestID, _ := uuid.NewV4()
+	body := PublishResponse{
+		MessageID: n.MessageID,
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, body)
+}
+
+func PublishBatch(c *gin.Context) {
+	values := formValues(c)
+	topic, ok := getTopic(c, values.Get("TopicArn"))
+	if !ok {
+		return
+	}
+	models.GetDB().Model(&topic).Related(&topic.Endpoints)
+
+	entries := parseEntries(values, "PublishBatchRequestEntries.member")
+	if code, message := validateBatchEntries(entries, ""); code != "" {
+		writeSenderErrorResponse(c, code, message)
+		return
+	}
+
+	response := PublishBatchResponse{
+		Successful: []PublishBatchResultEntry{},
+		Failed:     []BatchResultErrorEntry{},
+	}
+
+	ids := []string{}
+	notifications := []models.Notification{}
+	totalSize := 0
+	for _, entry := range entries {
+		n, code, message := newNotification(topic, entry)
+		totalSize += n.Size()
+		if code != "" {
+			response.Failed = append(response.Failed, BatchResultErrorEntry{
+				ID:          entry.Get("Id"),
+				Code:        code,
+				Message:     message,
+				SenderFault: true,
+			})
+			continue
+		}
+
+		ids = append(ids, entry.Get("Id"))
+		notifications = append(notifications, n)
+	}
+
+	if totalSize > maxMessageSize {
+		writeSenderErrorResponse(c, "BatchRequestTooLong", fmt.Sprintf("Batch requests cannot be longer than %d bytes. You have sent %d bytes.", maxMessageSize, totalSize))
+		return
+	}
+
+	for i, n := range notifications {
+		if err := topic.Publish(n); err != nil {
+			response.Failed = append(response.Failed, BatchResultErrorEntry{
+				ID:      ids[i],
+				Code:    "InternalError",
+				Message: err.Error(),
+			})
+			continue
+		}
+
+		response.Successful = append(response.Successful, PublishBatchResultEntry{
+			ID:        ids[i],
+			MessageID: n.MessageID,
+		})
+	}
+
+	requestID, _ := uuid.NewV4()
+	response.RequestID = requestID.String()
+	c.XML(http.StatusOK, response)
+}
+
+func GetTopicAttributes(c *gin.Context) {
+	topic, ok := getTopic(c, c.PostForm("TopicArn"))
+	if !ok {
+		return
+	}
+	models.GetDB().Model(&topic).Related(&topic.Endpoints)
+
+	requestID, _ := uuid.NewV4()
+	response := GetTopicAttributesResponse{
+		Attributes: attributeEntries(topic.TopicAttributes()),
+		RequestID:  requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+func SetTopicAttributes(c *gin.Context) {
+	topic, ok := getTopic(c, c.PostForm("TopicArn"))
+	if !ok {
+		return
+	}
+
+	name := c.PostForm("AttributeName")
+	if err := topic.SetTopicAttribute(name, c.PostForm("AttributeValue")); err != nil {
+		writeTopicAttributeErrorResponse(c, name, err)
+		return
+	}
+
+	db := models.GetDB()
+	db.Save(&topic)
+
+	requestID, _ := uuid.NewV4()
+	response := SetTopicAttributesResponse{
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+func GetSubscriptionAttributes(c *gin.Context) {
+	topic, subscription, ok := getSubscription(c, c.PostForm("SubscriptionArn"))
+	if !ok {
+		return
+	}
+
+	requestID, _ := uuid.NewV4()
+	response := GetSubscriptionAttributesResponse{
+		Attributes: attributeEntries(subscription.Attributes(topic)),
+		RequestID:  requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+func SetSubscriptionAttributes(c *gin.Context) {
+	topic, subscription, ok := getSubscription(c, c.PostForm("SubscriptionArn"))
+	if !ok {
+		return
+	}
+
+	name := c.PostForm("AttributeName")
+	if err := subscription.SetAttribute(topic, name, c.PostForm("AttributeValue")); err != nil {
+		writeTopicAttributeErrorResponse(c, name, err)
+		return
+	}
+
+	db := models.GetDB()
+	db.Save(&subscription)
+
+	requestID, _ := uuid.NewV4()
+	response := SetSubscriptionAttributesResponse{
+		RequestID: requestID.String(),
+	}
+	c.XML(http.StatusOK, response)
+}
+
+// getSubscription - returns the subscription of the ARN and its topic, the
+// topic must be owned by the user.
+func getSubscription(c *gin.Context, subscriptionARN string) (topic models.Resource, subscription models.Endpoint, ok bool) {
+	target, err := models.ParseSubscription(subscriptionARN)
+	if err != nil {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: SubscriptionArn")
+		return
+	}
+
+	topic, ok = getTopic(c, subscriptionARN[:strings.LastIndex(subscriptionARN, ":")])
+	if !ok {
+		return
+	}
+
+	db := models.GetDB()
+	if db.Where(models.Endpoint{ResourceID: topic.ID, Name: target.Name}).First(&subscription).RecordNotFound() {
+		writeSenderErrorResponse(c, "NotFound", "Subscription does not exist")
+		return topic, subscription, false
+	}
+
+	return topic, subscription, true
+}
+
+// attributeEntries - returns the attributes sorted by their names.
+func attributeEntries(attributes map[string]string) []AttributeEntry {
+	names := []string{}
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := []AttributeEntry{}
+	for _, name := range names {
+		entries = append(entries, AttributeEntry{Key: name, Value: attributes[name]})
+	}
+
+	return entries
+}
+
+// parseAttributeEntries - returns the attributes in `Attributes.entry.N`
+// parameters.
+func parseAttributeEntries(values url.Values) map[string]string {
+	attributes := map[string]string{}
+	for _, entry := range parseEntries(values, "Attributes.entry") {
+		attributes[entry.Get("key")] = entry.Get("value")
+	}
+
+	return attributes
+}
+
+func writeTopicAttributeErrorResponse(c *gin.Context, name string, err error) {
+	if err == models.ErrInvalidAttributeName {
+		writeSenderErrorResponse(c, "InvalidParameter", "Invalid parameter: AttributeName")
+		return
+	}
+
+	writeSenderErrorResponse(c, "InvalidParameter", fmt.Sprintf("Invalid parameter: Attributes Reason: %s: %s", name, err))
+}
@@ -0,0 +1,212 @@
+package controllers_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/inwinstack/kaoliang/pkg/controllers"
+	"github.com/inwinstack/kaoliang/pkg/models"
+)
+
+func teardownTopics() {
+	db := models.GetDB()
+	db.Exec("TRUNCATE TABLE endpoints;")
+	teardown()
+}
+
+// post - sends the form to the controller and returns its response.
+func post(controller gin.HandlerFunc, values url.Values) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/", strings.NewReader(values.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	controller(c)
+
+	return w
+}
+
+// newSubscribedTopic - creates a topic with n subscriptions.
+func newSubscribedTopic(accountID, name string, n int) models.Resource {
+	topic := models.Resource{Service: models.SNS, AccountID: accountID, Name: name}
+	for i := 0; i < n; i++ {
+		topic.Endpoints = append(topic.Endpoints, models.NewEndpoint("email", fmt.Sprintf("%s-%d@example.com", name, i)))
+	}
+	models.GetDB().Create(&topic)
+
+	return topic
+}
+
+func TestListTopics(t *testing.T) {
+	setup()
+	defer teardownTopics()
+
+	Convey("Given more topics than a page", t, func() {
+		for i := 0; i < 101; i++ {
+			newSubscribedTopic("tester", fmt.Sprintf("topic-%d", i), 0)
+		}
+		newSubscribedTopic("someone", "topic", 0)
+
+		Reset(teardownTopics)
+
+		Convey("When list topics page by page", func() {
+			w := post(controllers.ListTopics, url.Values{"Action": {"ListTopics"}})
+			first := controllers.ListTopicsResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &first)
+
+			w = post(controllers.ListTopics, url.Values{"Action": {"ListTopics"}, "NextToken": {first.NextToken}})
+			second := controllers.ListTopicsResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &second)
+
+			Convey("Each page should continue after the previous one", func() {
+				So(first.TopicARNs, ShouldHaveLength, 100)
+				So(first.NextToken, ShouldNotBeEmpty)
+				So(second.TopicARNs, ShouldHaveLength, 1)
+				So(second.TopicARNs[0].Name, ShouldEndWith, ":tester:topic-100")
+				So(second.NextToken, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When list topics with an invalid token", func() {
+			w := post(controllers.ListTopics, url.Values{"Action": {"ListTopics"}, "NextToken": {"foobar"}})
+			response := controllers.ErrorResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &response)
+
+			Convey("The token should be rejected", func() {
+				So(w.Code, ShouldEqual, 400)
+				So(response.Code, ShouldEqual, "InvalidParameter")
+			})
+		})
+	})
+}
+
+func TestListSubscriptions(t *testing.T) {
+	setup()
+	defer teardownTopics()
+
+	Convey("Given a topic with more subscriptions than a page and other topics", t, func() {
+		topic := newSubscribedTopic("tester", "foobar", 101)
+		newSubscribedTopic("tester", "other", 1)
+		newSubscribedTopic("someone", "foobar", 1)
+		models.GetDB().Create(&models.Resource{Service: models.SQS, AccountID: "tester", Name: "foobar"})
+
+		Reset(teardownTopics)
+
+		Convey("When list subscriptions of the topic page by page", func() {
+			values := url.Values{"Action": {"ListSubscriptionsByTopic"}, "TopicArn": {topic.ARN()}}
+			w := post(controllers.ListSubscriptionsByTopic, values)
+			first := controllers.ListSubscriptionsByTopicResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &first)
+
+			values.Set("NextToken", first.NextToken)
+			w = post(controllers.ListSubscriptionsByTopic, values)
+			second := controllers.ListSubscriptionsByTopicResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &second)
+
+			Convey("Only the subscriptions to the topic should be returned", func() {
+				So(first.SubscriptionARNs, ShouldHaveLength, 100)
+				So(first.NextToken, ShouldNotBeEmpty)
+				So(second.SubscriptionARNs, ShouldHaveLength, 1)
+				So(second.NextToken, ShouldBeEmpty)
+				for _, subscription := range append(first.SubscriptionARNs, second.SubscriptionARNs...) {
+					So(subscription.TopicARN, ShouldEqual, topic.ARN())
+					So(subscription.Owner, ShouldEqual, "tester")
+				}
+				So(second.SubscriptionARNs[0].Endpoint, ShouldEqual, "foobar-100@example.com")
+			})
+		})
+
+		Convey("When list subscriptions of the account page by page", func() {
+			values := url.Values{"Action": {"ListSubscriptions"}}
+			w := post(controllers.ListSubscriptions, values)
+			first := controllers.ListSubscriptionsResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &first)
+
+			values.Set("NextToken", first.NextToken)
+			w = post(controllers.ListSubscriptions, values)
+			second := controllers.ListSubscriptionsResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &second)
+
+			Convey("The subscriptions to all topics of the account should be returned", func() {
+				So(first.SubscriptionARNs, ShouldHaveLength, 100)
+				So(second.SubscriptionARNs, ShouldHaveLength, 2)
+				So(second.NextToken, ShouldBeEmpty)
+				So(second.SubscriptionARNs[1].Endpoint, ShouldEqual, "other-0@example.com")
+			})
+		})
+
+		Convey("When list subscriptions of a topic which does not exist", func() {
+			w := post(controllers.ListSubscriptionsByTopic, url.Values{
+				"Action":   {"ListSubscriptionsByTopic"},
+				"TopicArn": {strings.Replace(topic.ARN(), "foobar", "missing", 1)},
+			})
+			response := controllers.ErrorResponse{}
+			xml.Unmarshal(w.Body.Bytes(), &response)
+
+			Convey("The topic should not be found", func() {
+				So(w.Code, ShouldEqual, 400)
+				So(response.Code, ShouldEqual, "NotFound")
+			})
+		})
+	})
+}
+
+func TestUnsubscribeByURL(t *testing.T) {
+	setup()
+	defer teardownTopics()
+
+	Convey("Given a confirmed subscription", t, func() {
+		topic := newSubscribedTopic("tester", "foobar", 0)
+		subscription := models.NewEndpoint("http", "http://example.com/notify")
+		subscription.ResourceID = topic.ID
+		subscription.Confirm(subscription.Token)
+		models.GetDB().Create(&subscription)
+
+		// unsubscribe - visits the UnsubscribeURL of the subscription with the
+		// token.
+		unsubscribe := func(token string) *httptest.ResponseRecorder {
+			query := url.Values{"Action": {"Unsubscribe"}, "SubscriptionArn": {subscription.ARN(topic)}}
+			if token != "" {
+				query.Set("Token", token)
+			}
+			return serve(controllers.UnsubscribeByURL, "/?"+query.Encode())
+		}
+
+		// subscribed - reports whether the subscription still exists.
+		subscribed := func() bool {
+			return !models.GetDB().First(&models.Endpoint{}, subscription.ID).RecordNotFound()
+		}
+
+		Reset(teardownTopics)
+
+		Convey("When unsubscribe it without a token or with another token", func() {
+			withoutToken := unsubscribe("")
+			withOtherToken := unsubscribe("foobar")
+			response := controllers.ErrorResponse{}
+			xml.Unmarshal(withOtherToken.Body.Bytes(), &response)
+
+			Convey("It should not be unsubscribed", func() {
+				So(withoutToken.Code, ShouldEqual, 400)
+				So(withOtherToken.Code, ShouldEqual, 400)
+				So(response.Code, ShouldEqual, "InvalidParameter")
+				So(subscribed(), ShouldBeTrue)
+			})
+		})
+
+		Convey("When unsubscribe it with its token", func() {
+			w := unsubscribe(subscription.Token)
+
+			Convey("It should be unsubscribed", func() {
+				So(w.Code, ShouldEqual, 200)
+				So(subscribed(), ShouldBeFalse)
+			})
+		})
+	})
+}
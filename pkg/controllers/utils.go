@@ -2,8 +2,12 @@ package controllers
 
 import (
 	"bytes"
+	"encoding/base64"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -21,6 +25,92 @@ func PostForm(c *gin.Context, key string) string {
 	return value
 }
 
+// formValue - returns the request parameter from query string for GET
+// requests or from form body for POST requests.
+func formValue(c *gin.Context, key string) string {
+	if c.Request.Method == "POST" {
+		return c.PostForm(key)
+	}
+
+	return c.Query(key)
+}
+
+// formValues - returns all request parameters.
+func formValues(c *gin.Context) url.Values {
+	if c.Request.Method == "POST" {
+		c.Request.ParseForm()
+		return c.Request.PostForm
+	}
+
+	return c.Request.URL.Query()
+}
+
+// parseEntries - returns members of a list parameter such as
+// `SendMessageBatchRequestEntry.N.Id` ordered by N, each member holds the
+// parameters following `<prefix>.N.`.
+func parseEntries(values url.Values, prefix string) []url.Values {
+	members := map[int]url.Values{}
+	for key, value := range values {
+		if !strings.HasPrefix(key, prefix+".") {
+			continue
+		}
+
+		tokens := strings.SplitN(strings.TrimPrefix(key, prefix+"."), ".", 2)
+		index, err := strconv.Atoi(tokens[0])
+		if err != nil {
+			continue
+		}
+
+		if _, ok := members[index]; !ok {
+			members[index] = url.Values{}
+		}
+		if len(tokens) == 2 {
+			members[index][tokens[1]] = value
+		} else {
+			members[index][""] = value
+		}
+	}
+
+	indexes := []int{}
+	for index := range members {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	entries := []url.Values{}
+	for _, index := range indexes {
+		entries = append(entries, members[index])
+	}
+
+	return entries
+}
+
+// encodeNextToken - returns the pagination token that continues a listing
+// after the record with the ID.
+func encodeNextToken(id uint) string {
+	return base64.URLEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+// decodeNextToken - returns the record ID a listing continues after.
+func decodeNextToken(token string) (uint, bool) {
+	data, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, false
+	}
+
+	id, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint(id), true
+}
+
+// escapeLike - escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
 func ExtractAccessKeyV4(auth string) string {
 	auth = strings.Replace(auth, " ", "", -1)
 	if !strings.Contains(auth, "AWS4-HMAC-SHA256") {
@@ -0,0 +1,372 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package delivery
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/inwinstack/kaoliang/pkg/config"
+	"github.com/inwinstack/kaoliang/pkg/models"
+)
+
+// maxResponseSize - bytes of response bodies read, so that connections can
+// be reused without reading arbitrarily large responses.
+const maxResponseSize = 64 * 1024
+
+// pruneInterval - interval between prunes of expired delivery attempts.
+const pruneInterval = time.Hour
+
+const (
+	// leaseDuration - time the deliveries taken by a worker are kept for it
+	// without renewing its lease, they are requeued once it expires.
+	leaseDuration = 30 * time.Second
+
+	// leaseInterval - interval between renewals of the lease of a worker.
+	leaseInterval = 10 * time.Second
+)
+
+const (
+	// backlogSize - number of messages held for each endpoint while it has as
+	// many deliveries in progress as its concurrency.
+	backlogSize = 100
+
+	// deferDelay - delay of messages left in Redis because the backlog of
+	// their endpoint is full.
+	deferDelay = time.Second
+)
+
+// Worker - delivers messages to http and https endpoints. Up to the number of
+// workers messages are delivered at the same time, and those exceeding the
+// concurrency of an endpoint wait for deliveries to the endpoint to finish.
+// Messages taken by the worker are kept in Redis under its ID until they are
+// delivered, so that other workers requeue them if its lease expires.
+type Worker struct {
+	id          string
+	client      *http.Client
+	concurrency int
+	retention   time.Duration
+
+	// A slot is taken for each message being delivered by the worker
+	slots chan struct{}
+	wg    sync.WaitGroup
+
+	mu        sync.Mutex
+	endpoints map[string]*endpointDeliveries
+}
+
+type endpointDeliveries struct {
+	active  int
+	backlog []models.Delivery
+}
+
+// NewWorker - creates a worker with the delivery settings of the server,
+// connections to endpoints are pooled and reused by deliveries.
+func NewWorker(cfg *config.ServerConfig) (*Worker, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.DeliveryInsecureSkipVerify}
+	if cfg.DeliveryCAFile != "" {
+		pem, err := ioutil.ReadFile(cfg.DeliveryCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + cfg.DeliveryCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	workers, concurrency, timeout := cfg.DeliveryWorkers, cfg.DeliveryConcurrency, cfg.DeliveryTimeout
+	if workers < 1 {
+		workers = 1
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        workers,
+		MaxIdleConnsPerHost: concurrency,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	id, _ := uuid.NewV4()
+
+	return &Worker{
+		id: id.String(),
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+		concurrency: concurrency,
+		retention:   cfg.DeliveryAttemptRetention,
+		slots:       make(chan struct{}, workers),
+		endpoints:   map[string]*endpointDeliveries{},
+	}, nil
+}
+
+// Run - delivers queued messages until stop is closed, then waits for the
+// messages being delivered and puts the messages waiting for their endpoints
+// back to the queue. Messages held by workers whose lease has expired are
+// requeued when the worker starts and while it runs.
+func (w *Worker) Run(stop <-chan struct{}) {
+	w.renewLease()
+
+	done := make(chan struct{})
+	defer close(done)
+	go w.heartbeat(done)
+
+	if w.retention > 0 {
+		go w.prune(stop)
+	}
+
+	for {
+		select {
+		case <-stop:
+			w.mu.Lock()
+			for _, endpoint := range w.endpoints {
+				endpoint.backlog = nil
+			}
+			w.mu.Unlock()
+
+			w.wg.Wait()
+			if err := models.ReleaseDeliveryLease(w.id); err != nil {
+				log.Printf("An error occurred while releasing the lease of worker %s. %s\n", w.id, err)
+			}
+			return
+		case w.slots <- struct{}{}:
+		}
+
+		delivery, err := models.WaitDelivery(w.id, time.Second)
+		if err != nil {
+			log.Printf("An error occurred while waiting for deliveries. %s\n", err)
+			<-w.slots
+			time.Sleep(time.Second)
+			continue
+		}
+		if delivery == nil {
+			<-w.slots
+			continue
+		}
+
+		w.dispatch(*delivery)
+	}
+}
+
+// dispatch - starts delivering the message, unless the endpoint already has
+// as many deliveries in progress as its concurrency. Then the message waits
+// in the backlog of the endpoint without a slot, so that slow endpoints do
+// not hold up deliveries to others, or in Redis when the backlog is full.
+func (w *Worker) dispatch(delivery models.Delivery) {
+	w.mu.Lock()
+	endpoint, ok := w.endpoints[delivery.URI]
+	if !ok {
+		endpoint = &endpointDeliveries{}
+		w.endpoints[delivery.URI] = endpoint
+	}
+
+	if endpoint.active < w.concurrency {
+		endpoint.active++
+		w.wg.Add(1)
+		w.mu.Unlock()
+		go w.run(delivery)
+		return
+	}
+
+	backlogged := len(endpoint.backlog) < backlogSize
+	if backlogged {
+		endpoint.backlog = append(endpoint.backlog, delivery)
+	}
+	w.mu.Unlock()
+	<-w.slots
+
+	if !backlogged {
+		if err := models.DeferDelivery(delivery, deferDelay); err != nil {
+			log.Printf("An error occurred while deferring the delivery of message %s. %s\n", delivery.MessageID, err)
+		}
+	}
+}
+
+// run - delivers the message and then the messages waiting for the endpoint,
+// with the slot taken for the message.
+func (w *Worker) run(delivery models.Delivery) {
+	defer w.wg.Done()
+	defer func() { <-w.slots }()
+
+	for {
+		w.deliver(delivery)
+		if err := models.CompleteDelivery(delivery); err != nil {
+			log.Printf("An error occurred while completing the delivery of message %s. %s\n", delivery.MessageID, err)
+		}
+
+		w.mu.Lock()
+		endpoint := w.endpoints[delivery.URI]
+		if len(endpoint.backlog) == 0 {
+			endpoint.active--
+			if endpoint.active == 0 {
+				delete(w.endpoints, delivery.URI)
+			}
+			w.mu.Unlock()
+			return
+		}
+
+		delivery = endpoint.backlog[0]
+		endpoint.backlog = endpoint.backlog[1:]
+		w.mu.Unlock()
+	}
+}
+
+// heartbeat - renews the lease of the worker every lease interval until done
+// is closed.
+func (w *Worker) heartbeat(done <-chan struct{}) {
+	ticker := time.NewTicker(leaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			w.renewLease()
+		}
+	}
+}
+
+// renewLease - renews the lease of the worker, and requeues the messages held
+// by workers whose lease has expired.
+func (w *Worker) renewLease() {
+	if err := models.RenewDeliveryLease(w.id, leaseDuration); err != nil {
+		log.Printf("An error occurred while renewing the lease of worker %s. %s\n", w.id, err)
+	}
+
+	if requeued, err := models.RequeueExpiredDeliveries(); err != nil {
+		log.Printf("An error occurred while requeuing processing deliveries. %s\n", err)
+	} else if requeued > 0 {
+		log.Printf("Requeued %d deliveries left processing.\n", requeued)
+	}
+}
+
+// prune - deletes delivery attempts older than the retention period every
+// prune interval until stop is closed.
+func (w *Worker) prune(stop <-chan struct{}) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := models.PruneDeliveryAttempts(time.Now().Add(-w.retention)); err != nil {
+			log.Printf("An error occurred while pruning delivery attempts. %s\n", err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// post - posts the message with its headers to the uri.
+func (w *Worker) post(uri string, delivery models.Delivery) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, uri, strings.NewReader(delivery.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range delivery.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return w.client.Do(req)
+}
+
+// deliver - posts the message to the endpoint and records the attempt, any
+// 2xx response is successful. Failed deliveries are retried or sent to the
+// dead-letter queue of the subscription.
+func (w *Worker) deliver(delivery models.Delivery) {
+	// Subscriptions created before endpoints were validated may have no scheme
+	uri := delivery.URI
+	if !strings.Contains(uri, "://") {
+		uri = "http://" + uri
+	}
+
+	attempt := models.DeliveryAttempt{
+		EndpointID: delivery.EndpointID,
+		URI:        delivery.URI,
+		MessageID:  delivery.MessageID,
+		Attempt:    delivery.Attempt,
+	}
+
+	start := time.Now()
+	resp, err := w.post(uri, delivery)
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+		resp.Body.Close()
+
+		attempt.StatusCode = resp.StatusCode
+		attempt.Succeeded = resp.StatusCode >= 200 && resp.StatusCode < 300
+	}
+	attempt.Duration = int64(time.Since(start) / time.Millisecond)
+
+	if err := models.GetDB().Create(&attempt).Error; err != nil {
+		log.Printf("An error occurred while recording the delivery of message %s. %s\n", delivery.MessageID, err)
+	}
+
+	log.Printf("Message: %s, Endpoint: %s, Attempt: %d, Status: %d, Duration: %dms %s", delivery.MessageID, delivery.URI, delivery.Attempt, attempt.StatusCode, attempt.Duration, attempt.Error)
+
+	if attempt.Succeeded {
+		return
+	}
+
+	// Endpoints failing to respond, throttling or failing with server errors
+	// are retried, other responses are permanent failures
+	retryable := err != nil || attempt.StatusCode == http.StatusTooManyRequests || attempt.StatusCode >= 500
+	reason := attempt.Error
+	if reason == "" {
+		reason = fmt.Sprintf("The endpoint responded with status %d.", attempt.StatusCode)
+	}
+
+	if err := models.FailDelivery(delivery, retryable, reason); err != nil {
+		log.Printf("An error occurred while retrying the delivery of message %s. %s\n", delivery.MessageID, err)
+	}
+}
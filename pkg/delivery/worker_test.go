@@ -0,0 +1,226 @@
+package delivery_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/inwinstack/kaoliang/pkg/config"
+	"github.com/inwinstack/kaoliang/pkg/delivery"
+	"github.com/inwinstack/kaoliang/pkg/models"
+)
+
+func setup() {
+	os.Setenv("RGW_DNS_NAME", "cloud.inwinstack.com")
+	os.Setenv("DATABASE_URL", "root:my-secret-pw@tcp(127.0.0.1:3306)/test_kaoliang?charset=utf8&parseTime=True&loc=Local")
+	config.SetServerConfig()
+	models.SetDB()
+	models.Migrate()
+	models.SetCache()
+}
+
+func teardown() {
+	db := models.GetDB()
+	db.Exec("TRUNCATE TABLE delivery_attempts;")
+}
+
+// newTopic - returns a topic with a confirmed subscription of each uri.
+func newTopic(uris ...string) models.Resource {
+	topic := models.Resource{Service: models.SNS, AccountID: "tester", Name: "foobar"}
+	for _, uri := range uris {
+		endpoint := models.NewEndpoint("http", uri)
+		endpoint.Confirm(endpoint.Token)
+		topic.Endpoints = append(topic.Endpoints, endpoint)
+	}
+
+	return topic
+}
+
+// startWorker - runs a worker until the returned function is called, which
+// waits for the worker to stop.
+func startWorker(workers int, concurrency int) func() {
+	worker, err := delivery.NewWorker(&config.ServerConfig{
+		DeliveryWorkers:     workers,
+		DeliveryConcurrency: concurrency,
+		DeliveryTimeout:     5 * time.Second,
+	})
+	So(err, ShouldBeNil)
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		worker.Run(stop)
+		close(stopped)
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// receive - returns the bodies received from the channel within the timeout,
+// up to n of them.
+func receive(received <-chan string, n int, timeout time.Duration) []string {
+	bodies := []string{}
+	deadline := time.After(timeout)
+	for len(bodies) < n {
+		select {
+		case body := <-received:
+			bodies = append(bodies, body)
+		case <-deadline:
+			return bodies
+		}
+	}
+
+	return bodies
+}
+
+// newEndpointServer - returns a server which sends the bodies it receives to
+// the channel.
+func newEndpointServer(received chan<- string, handle func()) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		handle()
+		received <- string(body)
+	}))
+}
+
+func TestEndpointConcurrency(t *testing.T) {
+	setup()
+	defer teardown()
+
+	Convey("Given an endpoint which is slow to respond", t, func() {
+		var mu sync.Mutex
+		active, maxActive := 0, 0
+		received := make(chan string, 10)
+		server := newEndpointServer(received, func() {
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+
+			time.Sleep(100 * time.Millisecond)
+
+			mu.Lock()
+			active--
+			mu.Unlock()
+		})
+		defer server.Close()
+
+		topic := newTopic(server.URL)
+
+		Convey("When more messages than its concurrency are published", func() {
+			for i := 0; i < 6; i++ {
+				So(topic.Publish(models.NewNotification(topic, "foobar")), ShouldBeNil)
+			}
+
+			stopWorker := startWorker(8, 2)
+			bodies := receive(received, 6, 5*time.Second)
+			stopWorker()
+
+			Convey("At most its concurrency of messages should be delivered at the same time", func() {
+				So(bodies, ShouldHaveLength, 6)
+				mu.Lock()
+				defer mu.Unlock()
+				So(maxActive, ShouldEqual, 2)
+			})
+		})
+	})
+
+	Convey("Given an endpoint which does not respond and another endpoint", t, func() {
+		release := make(chan struct{})
+		hanging := make(chan string, 10)
+		hangingServer := newEndpointServer(hanging, func() { <-release })
+		defer hangingServer.Close()
+
+		received := make(chan string, 10)
+		server := newEndpointServer(received, func() {})
+		defer server.Close()
+
+		hangingTopic := newTopic(hangingServer.URL)
+		topic := newTopic(server.URL)
+
+		Convey("When messages to the endpoint which does not respond fill the workers", func() {
+			for i := 0; i < 3; i++ {
+				So(hangingTopic.Publish(models.NewNotification(hangingTopic, "foobar")), ShouldBeNil)
+			}
+			So(topic.Publish(models.NewNotification(topic, "foobar")), ShouldBeNil)
+
+			stopWorker := startWorker(2, 1)
+			bodies := receive(received, 1, 3*time.Second)
+			close(release)
+			hangingBodies := receive(hanging, 3, 5*time.Second)
+			stopWorker()
+
+			Convey("Messages to the other endpoint should still be delivered", func() {
+				So(bodies, ShouldHaveLength, 1)
+				So(hangingBodies, ShouldHaveLength, 3)
+			})
+		})
+	})
+}
+
+func TestRequeueDeliveries(t *testing.T) {
+	setup()
+	defer teardown()
+
+	Convey("Given a message taken by a worker which is still running", t, func() {
+		received := make(chan string, 10)
+		server := newEndpointServer(received, func() {})
+		defer server.Close()
+
+		topic := newTopic(server.URL)
+		So(topic.Publish(models.NewNotification(topic, "foobar")), ShouldBeNil)
+
+		So(models.RenewDeliveryLease("running", time.Minute), ShouldBeNil)
+		taken, err := models.WaitDelivery("running", time.Second)
+		So(err, ShouldBeNil)
+		So(taken, ShouldNotBeNil)
+
+		Convey("When another worker starts and then the running worker stops", func() {
+			stopWorker := startWorker(2, 1)
+			early := receive(received, 1, 2*time.Second)
+			So(models.ReleaseDeliveryLease("running"), ShouldBeNil)
+			late := receive(received, 1, 3*time.Second)
+			stopWorker()
+
+			Convey("The message should only be delivered once the running worker stops", func() {
+				So(early, ShouldBeEmpty)
+				So(late, ShouldHaveLength, 1)
+			})
+		})
+	})
+
+	Convey("Given a message taken by a worker whose lease has expired", t, func() {
+		received := make(chan string, 10)
+		server := newEndpointServer(received, func() {})
+		defer server.Close()
+
+		topic := newTopic(server.URL)
+		So(topic.Publish(models.NewNotification(topic, "foobar")), ShouldBeNil)
+
+		So(models.RenewDeliveryLease("stopped", -time.Second), ShouldBeNil)
+		taken, err := models.WaitDelivery("stopped", time.Second)
+		So(err, ShouldBeNil)
+		So(taken, ShouldNotBeNil)
+
+		Convey("When another worker starts", func() {
+			stopWorker := startWorker(2, 1)
+			bodies := receive(received, 1, 3*time.Second)
+			stopWorker()
+
+			Convey("The message should be delivered", func() {
+				So(bodies, ShouldHaveLength, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,85 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"sync"
+	"time"
+
+	"github.com/minio/minio/pkg/event"
+)
+
+// accessedBucketsTTL - duration the buckets with ObjectAccessed rules are
+// cached, which bounds how long changes made by other processes take effect.
+const accessedBucketsTTL = 10 * time.Second
+
+// Buckets whose notification configurations have ObjectAccessed rules
+var accessedBuckets struct {
+	sync.Mutex
+	buckets   map[string]bool
+	expiresAt time.Time
+}
+
+const accessedBucketsQuery = `
+SELECT configs.bucket FROM configs
+JOIN queues ON queues.config_id = configs.id AND queues.deleted_at IS NULL
+JOIN events ON events.queue_id = queues.id AND events.deleted_at IS NULL
+WHERE configs.deleted_at IS NULL AND events.name IN (?)
+UNION
+SELECT configs.bucket FROM configs
+JOIN topics ON topics.config_id = configs.id AND topics.deleted_at IS NULL
+JOIN events ON events.topic_id = topics.id AND events.deleted_at IS NULL
+WHERE configs.deleted_at IS NULL AND events.name IN (?)`
+
+// HasObjectAccessedRules - reports whether the notification configuration of
+// the bucket has ObjectAccessed rules, so that reads of other buckets skip
+// looking up their rules. The buckets are loaded by one query and cached.
+func HasObjectAccessedRules(bucket string) bool {
+	accessedBuckets.Lock()
+	defer accessedBuckets.Unlock()
+
+	if accessedBuckets.buckets == nil || time.Now().After(accessedBuckets.expiresAt) {
+		names := []event.Name{event.ObjectAccessedAll, event.ObjectAccessedGet, event.ObjectAccessedHead}
+		rows, err := db.Raw(accessedBucketsQuery, names, names).Rows()
+		if err != nil {
+			// Rules are looked up when the buckets can not be loaded
+			return true
+		}
+		defer rows.Close()
+
+		buckets := map[string]bool{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return true
+			}
+			buckets[name] = true
+		}
+
+		accessedBuckets.buckets = buckets
+		accessedBuckets.expiresAt = time.Now().Add(accessedBucketsTTL)
+	}
+
+	return accessedBuckets.buckets[bucket]
+}
+
+// ResetObjectAccessedRules - discards the cached buckets with ObjectAccessed
+// rules after notification configurations change.
+func ResetObjectAccessedRules() {
+	accessedBuckets.Lock()
+	defer accessedBuckets.Unlock()
+
+	accessedBuckets.buckets = nil
+}
@@ -25,7 +25,7 @@ type Event struct {
 
 // MarshalXML - encodes to XML data.
 func (event *Event) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
-	return e.EncodeElement(event.Name.String(), start)
+	return e.EncodeElement(EventNameString(event.Name), start)
 }
 
 func (e *Event) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
@@ -34,7 +34,7 @@ func (e *Event) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 		return err
 	}
 
-	eventName, err := event.ParseName(s)
+	eventName, err := ParseEventName(s)
 	if err != nil {
 		return err
 	}
@@ -139,7 +139,7 @@ func (q Queue) ToRulesMap() RulesMap {
 		names = append(names, e.Name)
 	}
 
-	return NewRulesMap(names, pattern, q.Resource)
+	return NewRulesMap(names, pattern, Target{q.QueueIdentifier, q.Resource})
 }
 
 type Queue struct {
@@ -187,7 +187,7 @@ func (t Topic) ToRulesMap() RulesMap {
 		names = append(names, e.Name)
 	}
 
-	return NewRulesMap(names, pattern, t.Resource)
+	return NewRulesMap(names, pattern, Target{t.TopicIdentifier, t.Resource})
 }
 
 type Config struct {
@@ -225,16 +225,23 @@ func (conf Config) ToRulesMap() RulesMap {
 	return rulesMap
 }
 
-type Rules map[string][]Resource
+// Target - the queue or topic of a notification configuration, with the Id
+// of the configuration.
+type Target struct {
+	ConfigurationID string
+	Resource
+}
+
+type Rules map[string][]Target
 
-// Match - returns []Resource matching object name in rules.
-func (rules Rules) Match(objectName string) []Resource {
-	var matched []Resource
+// Match - returns []Target matching object name in rules.
+func (rules Rules) Match(objectName string) []Target {
+	var matched []Target
 
-	for pattern, resources := range rules {
+	for pattern, targets := range rules {
 		if wildcard.MatchSimple(pattern, objectName) {
-			for _, resource := range resources {
-				matched = append(matched, resource)
+			for _, target := range targets {
+				matched = append(matched, target)
 			}
 		}
 	}
@@ -246,8 +253,8 @@ func (rules Rules) Match(objectName string) []Resource {
 func (rules Rules) Clone() Rules {
 	rulesCopy := make(Rules)
 
-	for pattern, resource := range rules {
-		rulesCopy[pattern] = resource
+	for pattern, targets := range rules {
+		rulesCopy[pattern] = targets
 	}
 
 	return rulesCopy
@@ -257,9 +264,9 @@ func (rules Rules) Clone() Rules {
 func (rules Rules) Union(rules2 Rules) Rules {
 	nrules := rules.Clone()
 
-	for pattern, resources := range rules2 {
-		for _, resource := range resources {
-			nrules[pattern] = append(nrules[pattern], resource)
+	for pattern, targets := range rules2 {
+		for _, target := range targets {
+			nrules[pattern] = append(nrules[pattern], target)
 		}
 	}
 
@@ -268,13 +275,13 @@ func (rules Rules) Union(rules2 Rules) Rules {
 
 type RulesMap map[event.Name]Rules
 
-// add - adds event names, prefixes, suffixes and resource to rules map.
-func (rulesMap RulesMap) add(eventNames []event.Name, pattern string, resource Resource) {
+// add - adds event names, prefixes, suffixes and target to rules map.
+func (rulesMap RulesMap) add(eventNames []event.Name, pattern string, target Target) {
 	rules := make(Rules)
-	rules[pattern] = append(rules[pattern], resource)
+	rules[pattern] = append(rules[pattern], target)
 
 	for _, eventName := range eventNames {
-		for _, name := range eventName.Expand() {
+		for _, name := range ExpandEventName(eventName) {
 			rulesMap[name] = rulesMap[name].Union(rules)
 		}
 	}
@@ -288,13 +295,13 @@ func (rulesMap RulesMap) Add(rulesMap2 RulesMap) {
 }
 
 // NewRulesMap - creates new rules map with given values.
-func NewRulesMap(eventNames []event.Name, pattern string, resource Resource) RulesMap {
+func NewRulesMap(eventNames []event.Name, pattern string, target Target) RulesMap {
 	// If pattern is empty, add '*' wildcard to match all.
 	if pattern == "" {
 		pattern = "*"
 	}
 
 	rulesMap := make(RulesMap)
-	rulesMap.add(eventNames, pattern, resource)
+	rulesMap.add(eventNames, pattern, target)
 	return rulesMap
 }
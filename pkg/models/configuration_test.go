@@ -0,0 +1,36 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+	"github.com/minio/minio/pkg/event"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRulesMapConfigurationID(t *testing.T) {
+	Convey("Given a notification configuration of a queue and a topic", t, func() {
+		config := models.Config{
+			Queues: []models.Queue{{
+				QueueIdentifier: "QueueConfig",
+				Events:          []models.Event{{Name: event.ObjectCreatedPut}},
+				Resource:        models.Resource{Service: models.SQS, Name: "queue"},
+			}},
+			Topics: []models.Topic{{
+				TopicIdentifier: "TopicConfig",
+				Events:          []models.Event{{Name: event.ObjectCreatedPut}},
+				Resource:        models.Resource{Service: models.SNS, Name: "topic"},
+			}},
+		}
+
+		Convey("Matched targets should have the Id of their configuration", func() {
+			ids := map[string]string{}
+			for _, target := range config.ToRulesMap()[event.ObjectCreatedPut].Match("photo.jpg") {
+				ids[target.Name] = target.ConfigurationID
+			}
+
+			So(ids, ShouldResemble, map[string]string{"queue": "QueueConfig", "topic": "TopicConfig"})
+		})
+	})
+}
@@ -35,7 +35,28 @@ func SetDB() {
 }
 
 func Migrate() {
-	db.AutoMigrate(&Resource{}, &Endpoint{}, &Event{}, &S3Key{}, &FilterRuleList{}, &FilterRule{}, &Queue{}, &Topic{}, &Config{})
+	db.AutoMigrate(&Resource{}, &Endpoint{}, &Event{}, &S3Key{}, &FilterRuleList{}, &FilterRule{}, &Queue{}, &Topic{}, &Config{}, &DeliveryAttempt{})
+
+	// Delivery attempts are pruned by the time they were made
+	if !db.Dialect().HasIndex("delivery_attempts", "idx_delivery_attempts_created_at") {
+		db.Model(&DeliveryAttempt{}).AddIndex("idx_delivery_attempts_created_at", "created_at")
+	}
+
+	// Queues created before queue attributes existed have no valid retention
+	// period, give them the default attributes.
+	db.Model(&Resource{}).Where("service = ? AND message_retention_period = 0", SQS).Updates(map[string]interface{}{
+		"visibility_timeout":       DefaultVisibilityTimeout,
+		"message_retention_period": DefaultMessageRetentionPeriod,
+		"maximum_message_size":     DefaultMaximumMessageSize,
+	})
+
+	// Subscriptions confirmed before they had tokens to unsubscribe by URL
+	// get new tokens
+	endpoints := []Endpoint{}
+	db.Where("pending_confirmation = ? AND (token = '' OR token IS NULL)", false).Find(&endpoints)
+	for _, endpoint := range endpoints {
+		db.Model(&endpoint).Update("token", newToken())
+	}
 }
 
 func GetDB() *gorm.DB {
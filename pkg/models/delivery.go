@@ -0,0 +1,268 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// deliveriesKey - key of the Redis list of deliveries waiting for the
+	// delivery worker.
+	deliveriesKey = "sns:deliveries"
+
+	// retriesKey - key of the Redis sorted set of deliveries to be retried,
+	// scored by the time they are due.
+	retriesKey = "sns:deliveries:retries"
+
+	// processingKey - prefix of the keys of the Redis lists of deliveries
+	// taken by each delivery worker, they are removed once they are delivered
+	// or failed.
+	processingKey = "sns:deliveries:processing:"
+
+	// workersKey - key of the Redis sorted set of delivery workers, scored by
+	// the time their lease expires.
+	workersKey = "sns:deliveries:workers"
+)
+
+// Moves due retries to the list of deliveries, and returns the number of
+// moved deliveries.
+var requeueRetriesScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, delivery in ipairs(due) do
+	redis.call('ZREM', KEYS[1], delivery)
+	redis.call('LPUSH', KEYS[2], delivery)
+end
+return #due
+`)
+
+// Moves the deliveries left processing by a worker back to the list of
+// deliveries unless its lease has been renewed, and returns the number of
+// moved deliveries.
+var requeueProcessingScript = redis.NewScript(`
+local expiry = redis.call('ZSCORE', KEYS[3], ARGV[1])
+if expiry and tonumber(expiry) > tonumber(ARGV[2]) then
+	return 0
+end
+local requeued = 0
+while redis.call('RPOPLPUSH', KEYS[1], KEYS[2]) do
+	requeued = requeued + 1
+end
+redis.call('ZREM', KEYS[3], ARGV[1])
+return requeued
+`)
+
+// Delivery - a message to be delivered to an http or https endpoint, with
+// the headers describing the message.
+type Delivery struct {
+	EndpointID uint
+	URI        string
+	MessageID  string
+	Body       string
+	Headers    map[string]string
+	Attempt    int
+
+	// The delivery as taken from the list of deliveries, and the list of
+	// processing deliveries it is kept in
+	data       string
+	processing string
+}
+
+// DeliveryAttempt - the result of an attempt to deliver a message, attempts
+// failed before the endpoint responds have no status code but an error.
+type DeliveryAttempt struct {
+	gorm.Model
+	EndpointID uint   `gorm:"index"`
+	URI        string `gorm:"type:varchar(2048)"`
+	MessageID  string `gorm:"index"`
+	Attempt    int
+	StatusCode int
+	Error      string `gorm:"type:text"`
+	Succeeded  bool
+
+	// Milliseconds from sending the request to receiving the response
+	Duration int64
+}
+
+// sendEvent - queues delivery of the body to the endpoint.
+func sendEvent(endpoint Endpoint, messageID string, body string, headers map[string]string) error {
+	data, _ := json.Marshal(Delivery{
+		EndpointID: endpoint.ID,
+		URI:        endpoint.URI,
+		MessageID:  messageID,
+		Body:       body,
+		Headers:    headers,
+		Attempt:    1,
+	})
+
+	return client.LPush(deliveriesKey, data).Err()
+}
+
+// WaitDelivery - waits up to timeout for a delivery taken by the worker, or
+// returns nil when there is none. Retries are waited for once they are due.
+// The delivery is kept in the list of processing deliveries of the worker
+// until CompleteDelivery or DeferDelivery.
+func WaitDelivery(workerID string, timeout time.Duration) (*Delivery, error) {
+	if err := requeueRetriesScript.Run(client, []string{retriesKey, deliveriesKey}, unixMilli(time.Now())).Err(); err != nil {
+		return nil, err
+	}
+
+	processing := processingKey + workerID
+	data, err := client.BRPopLPush(deliveriesKey, processing, timeout).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := Delivery{}
+	if err := json.Unmarshal([]byte(data), &delivery); err != nil {
+		client.LRem(processing, 1, data)
+		return nil, err
+	}
+	delivery.data = data
+	delivery.processing = processing
+
+	return &delivery, nil
+}
+
+// CompleteDelivery - removes the delivered or failed delivery from the list
+// of processing deliveries.
+func CompleteDelivery(delivery Delivery) error {
+	return client.LRem(delivery.processing, 1, delivery.data).Err()
+}
+
+// DeferDelivery - puts the delivery back to Redis to be taken again after the
+// delay, without counting it as an attempt.
+func DeferDelivery(delivery Delivery, delay time.Duration) error {
+	dueTime := time.Now().Add(delay)
+	_, err := client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(retriesKey, redis.Z{Score: float64(unixMilli(dueTime)), Member: delivery.data})
+		pipe.LRem(delivery.processing, 1, delivery.data)
+		return nil
+	})
+	return err
+}
+
+// RenewDeliveryLease - keeps the deliveries taken by the worker from being
+// requeued for the duration of the lease.
+func RenewDeliveryLease(workerID string, lease time.Duration) error {
+	expiry := unixMilli(time.Now().Add(lease))
+	return client.ZAdd(workersKey, redis.Z{Score: float64(expiry), Member: workerID}).Err()
+}
+
+// ReleaseDeliveryLease - puts the deliveries left processing by the stopped
+// worker back to the list of deliveries and forgets the worker.
+func ReleaseDeliveryLease(workerID string) error {
+	keys := []string{processingKey + workerID, deliveriesKey, workersKey}
+	return requeueProcessingScript.Run(client, keys, workerID, math.MaxInt64).Err()
+}
+
+// RequeueExpiredDeliveries - puts the deliveries left processing by workers
+// whose lease has expired back to the list of deliveries, and returns the
+// number of requeued deliveries. Such workers stopped or stalled, so their
+// deliveries may be delivered again but are not lost.
+func RequeueExpiredDeliveries() (int, error) {
+	now := strconv.FormatInt(unixMilli(time.Now()), 10)
+	workers, err := client.ZRangeByScore(workersKey, redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for _, workerID := range workers {
+		keys := []string{processingKey + workerID, deliveriesKey, workersKey}
+		n, err := requeueProcessingScript.Run(client, keys, workerID, now).Result()
+		if err != nil {
+			return requeued, err
+		}
+		requeued += int(n.(int64))
+	}
+
+	return requeued, nil
+}
+
+// PruneDeliveryAttempts - deletes the attempts made before the time, and
+// returns the number of deleted attempts.
+func PruneDeliveryAttempts(before time.Time) (int64, error) {
+	result := db.Unscoped().Where("created_at < ?", before).Delete(DeliveryAttempt{})
+	return result.RowsAffected, result.Error
+}
+
+// FailDelivery - handles a failed attempt of the delivery. Retryable failures
+// are retried by the retry policy of the subscription, then the message is
+// sent to the dead-letter queue of the subscription if it has one. Deliveries
+// to removed subscriptions are dropped.
+func FailDelivery(delivery Delivery, retryable bool, reason string) error {
+	endpoint := Endpoint{}
+	if delivery.EndpointID == 0 || db.First(&endpoint, delivery.EndpointID).RecordNotFound() {
+		return nil
+	}
+
+	topic := Resource{}
+	if db.First(&topic, endpoint.ResourceID).RecordNotFound() {
+		return nil
+	}
+
+	// The first retry follows the first attempt
+	policy := endpoint.RetryPolicy(topic)
+	if retryable && delivery.Attempt <= policy.NumRetries {
+		dueTime := time.Now().Add(policy.Delay(delivery.Attempt))
+		delivery.Attempt++
+		data, _ := json.Marshal(delivery)
+		return client.ZAdd(retriesKey, redis.Z{Score: float64(unixMilli(dueTime)), Member: data}).Err()
+	}
+
+	if endpoint.PendingConfirmation || endpoint.RedrivePolicy == "" {
+		return nil
+	}
+
+	return endpoint.sendToDeadLetterQueue(topic, delivery, reason)
+}
+
+// sendToDeadLetterQueue - sends the undeliverable message to the dead-letter
+// queue with the reason of the last failure, queues that no longer exist or
+// no longer permit the topic to send are skipped.
+func (e Endpoint) sendToDeadLetterQueue(topic Resource, delivery Delivery, reason string) error {
+	policy, err := ParseSubscriptionRedrivePolicy(e.RedrivePolicy)
+	if err != nil {
+		return nil
+	}
+
+	queue, ok := deadLetterQueue(*policy)
+	if !ok || !queue.AllowsSendFrom(topic) {
+		return nil
+	}
+
+	msg := NewMessage(delivery.Body)
+	msg.DelaySeconds = queue.DelaySeconds
+	msg.SenderID = topic.AccountID
+	msg.MessageAttributes = map[string]MessageAttribute{
+		"SubscriptionArn": {DataType: "String", StringValue: e.ARN(topic)},
+	}
+	if reason != "" {
+		msg.MessageAttributes["ErrorMessage"] = MessageAttribute{DataType: "String", StringValue: reason}
+	}
+
+	_, err = queue.SendMessages(msg)
+	return err
+}
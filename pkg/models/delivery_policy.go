@@ -0,0 +1,212 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+const (
+	maxDelayTarget = 3600
+	maxNumRetries  = 100
+)
+
+// DefaultRetryPolicy - the retry policy of http and https endpoints when
+// neither the topic nor the subscription has a delivery policy.
+var DefaultRetryPolicy = RetryPolicy{
+	MinDelayTarget:  20,
+	MaxDelayTarget:  20,
+	NumRetries:      3,
+	BackoffFunction: "linear",
+}
+
+// RetryPolicy - how failed deliveries to an endpoint are retried. Retries go
+// through the phases of immediate retries, retries after minDelayTarget,
+// retries backing off from minDelayTarget to maxDelayTarget and retries after
+// maxDelayTarget, numRetries counts the retries of all phases.
+type RetryPolicy struct {
+	MinDelayTarget     int    `json:"minDelayTarget"`
+	MaxDelayTarget     int    `json:"maxDelayTarget"`
+	NumRetries         int    `json:"numRetries"`
+	NumNoDelayRetries  int    `json:"numNoDelayRetries"`
+	NumMinDelayRetries int    `json:"numMinDelayRetries"`
+	NumMaxDelayRetries int    `json:"numMaxDelayRetries"`
+	BackoffFunction    string `json:"backoffFunction"`
+}
+
+// TopicDeliveryPolicy - the DeliveryPolicy attribute of topics, which holds
+// the retry policy of http and https endpoints. Subscriptions override it by
+// their own delivery policy unless disableSubscriptionOverrides is set.
+type TopicDeliveryPolicy struct {
+	HTTP *HTTPDeliveryPolicy `json:"http,omitempty"`
+}
+
+// HTTPDeliveryPolicy - the delivery policy of topics for http and https
+// endpoints.
+type HTTPDeliveryPolicy struct {
+	DefaultHealthyRetryPolicy    *RetryPolicy `json:"defaultHealthyRetryPolicy,omitempty"`
+	DisableSubscriptionOverrides bool         `json:"disableSubscriptionOverrides"`
+}
+
+// SubscriptionDeliveryPolicy - the DeliveryPolicy attribute of subscriptions.
+type SubscriptionDeliveryPolicy struct {
+	HealthyRetryPolicy *RetryPolicy `json:"healthyRetryPolicy,omitempty"`
+}
+
+// SubscriptionRedrivePolicy - the dead-letter queue messages are sent to
+// after their delivery to the subscription failed.
+type SubscriptionRedrivePolicy struct {
+	DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+}
+
+// ParseTopicDeliveryPolicy - parses and validates the DeliveryPolicy
+// attribute of topics.
+func ParseTopicDeliveryPolicy(s string) (*TopicDeliveryPolicy, error) {
+	policy := TopicDeliveryPolicy{}
+	if err := json.Unmarshal([]byte(s), &policy); err != nil {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	if policy.HTTP != nil && policy.HTTP.DefaultHealthyRetryPolicy != nil {
+		if err := policy.HTTP.DefaultHealthyRetryPolicy.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &policy, nil
+}
+
+// ParseSubscriptionDeliveryPolicy - parses and validates the DeliveryPolicy
+// attribute of subscriptions.
+func ParseSubscriptionDeliveryPolicy(s string) (*SubscriptionDeliveryPolicy, error) {
+	policy := SubscriptionDeliveryPolicy{}
+	if err := json.Unmarshal([]byte(s), &policy); err != nil {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	if policy.HealthyRetryPolicy != nil {
+		if err := policy.HealthyRetryPolicy.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &policy, nil
+}
+
+// ParseSubscriptionRedrivePolicy - parses the RedrivePolicy attribute of
+// subscriptions, the dead-letter queue must be an SQS queue.
+func ParseSubscriptionRedrivePolicy(s string) (*SubscriptionRedrivePolicy, error) {
+	policy := SubscriptionRedrivePolicy{}
+	if err := json.Unmarshal([]byte(s), &policy); err != nil {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	target, err := ParseARN(policy.DeadLetterTargetArn)
+	if err != nil || target.Service != SQS {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	return &policy, nil
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MinDelayTarget < 1 || p.MaxDelayTarget < p.MinDelayTarget || p.MaxDelayTarget > maxDelayTarget {
+		return ErrInvalidAttributeValue
+	}
+
+	if p.NumRetries < 0 || p.NumRetries > maxNumRetries {
+		return ErrInvalidAttributeValue
+	}
+
+	if p.NumNoDelayRetries < 0 || p.NumMinDelayRetries < 0 || p.NumMaxDelayRetries < 0 {
+		return ErrInvalidAttributeValue
+	}
+
+	if p.NumNoDelayRetries+p.NumMinDelayRetries+p.NumMaxDelayRetries > p.NumRetries {
+		return ErrInvalidAttributeValue
+	}
+
+	switch p.BackoffFunction {
+	case "", "linear", "arithmetic", "geometric", "exponential":
+	default:
+		return ErrInvalidAttributeValue
+	}
+
+	return nil
+}
+
+// Delay - returns the delay before the retry, retries are counted from 1.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	minDelay := time.Duration(p.MinDelayTarget) * time.Second
+	maxDelay := time.Duration(p.MaxDelayTarget) * time.Second
+	backoffRetries := p.NumRetries - p.NumNoDelayRetries - p.NumMinDelayRetries - p.NumMaxDelayRetries
+
+	switch {
+	case retry <= p.NumNoDelayRetries:
+		return 0
+	case retry <= p.NumNoDelayRetries+p.NumMinDelayRetries:
+		return minDelay
+	case retry > p.NumNoDelayRetries+p.NumMinDelayRetries+backoffRetries:
+		return maxDelay
+	}
+
+	// The first backoff retry is after minDelayTarget and the last one after
+	// maxDelayTarget
+	if backoffRetries == 1 || minDelay == maxDelay {
+		return minDelay
+	}
+	step := retry - p.NumNoDelayRetries - p.NumMinDelayRetries - 1
+	x := float64(step) / float64(backoffRetries-1)
+
+	var y float64
+	switch p.BackoffFunction {
+	case "arithmetic":
+		y = x * x
+	case "geometric":
+		ratio := float64(p.MaxDelayTarget) / float64(p.MinDelayTarget)
+		y = (math.Pow(ratio, x) - 1) / (ratio - 1)
+	case "exponential":
+		y = (math.Exp2(float64(step)) - 1) / (math.Exp2(float64(backoffRetries-1)) - 1)
+	default:
+		y = x
+	}
+
+	return minDelay + time.Duration(y*float64(maxDelay-minDelay))
+}
+
+// RetryPolicy - returns the retry policy of the subscription to the topic,
+// which is the one of the subscription unless the topic disables overrides.
+func (e Endpoint) RetryPolicy(topic Resource) RetryPolicy {
+	var topicPolicy *TopicDeliveryPolicy
+	if topic.DeliveryPolicy != "" {
+		topicPolicy, _ = ParseTopicDeliveryPolicy(topic.DeliveryPolicy)
+	}
+
+	overridable := topicPolicy == nil || topicPolicy.HTTP == nil || !topicPolicy.HTTP.DisableSubscriptionOverrides
+	if overridable && e.DeliveryPolicy != "" {
+		policy, err := ParseSubscriptionDeliveryPolicy(e.DeliveryPolicy)
+		if err == nil && policy.HealthyRetryPolicy != nil {
+			return *policy.HealthyRetryPolicy
+		}
+	}
+
+	if topicPolicy != nil && topicPolicy.HTTP != nil && topicPolicy.HTTP.DefaultHealthyRetryPolicy != nil {
+		return *topicPolicy.HTTP.DefaultHealthyRetryPolicy
+	}
+
+	return DefaultRetryPolicy
+}
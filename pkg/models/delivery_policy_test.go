@@ -0,0 +1,59 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	Convey("Given a retry policy with all phases", t, func() {
+		policy := models.RetryPolicy{
+			MinDelayTarget:     10,
+			MaxDelayTarget:     100,
+			NumRetries:         7,
+			NumNoDelayRetries:  1,
+			NumMinDelayRetries: 1,
+			NumMaxDelayRetries: 1,
+			BackoffFunction:    "linear",
+		}
+
+		Convey("Retries should go through the phases", func() {
+			So(policy.Delay(1), ShouldEqual, 0)
+			So(policy.Delay(2), ShouldEqual, 10*time.Second)
+			So(policy.Delay(3), ShouldEqual, 10*time.Second)
+			So(policy.Delay(4), ShouldEqual, 40*time.Second)
+			So(policy.Delay(5), ShouldEqual, 70*time.Second)
+			So(policy.Delay(6), ShouldEqual, 100*time.Second)
+			So(policy.Delay(7), ShouldEqual, 100*time.Second)
+		})
+	})
+}
+
+func TestEndpointRetryPolicy(t *testing.T) {
+	Convey("Given a subscription with a delivery policy", t, func() {
+		endpoint := models.Endpoint{
+			Protocol:       "http",
+			DeliveryPolicy: `{"healthyRetryPolicy": {"minDelayTarget": 1, "maxDelayTarget": 2, "numRetries": 9}}`,
+		}
+
+		Convey("When the topic has a delivery policy", func() {
+			topic := models.Resource{DeliveryPolicy: `{"http": {"defaultHealthyRetryPolicy": {"minDelayTarget": 5, "maxDelayTarget": 5, "numRetries": 1}}}`}
+
+			Convey("The policy of the subscription should override it", func() {
+				So(endpoint.RetryPolicy(topic).NumRetries, ShouldEqual, 9)
+			})
+		})
+
+		Convey("When the topic disables subscription overrides", func() {
+			topic := models.Resource{DeliveryPolicy: `{"http": {"defaultHealthyRetryPolicy": {"minDelayTarget": 5, "maxDelayTarget": 5, "numRetries": 1}, "disableSubscriptionOverrides": true}}`}
+
+			Convey("The policy of the topic should be used", func() {
+				So(endpoint.RetryPolicy(topic).NumRetries, ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -15,18 +15,185 @@ Licensed under the Apache License, Version 2.0 (the "License");
 package models
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	"github.com/minio/minio/pkg/event"
+	"github.com/satori/go.uuid"
+
+	"github.com/inwinstack/kaoliang/pkg/config"
 )
 
+var ErrInvalidToken = errors.New("Invalid token")
+
+// confirmationTokenTTL - duration a subscription can be confirmed after the
+// confirmation is sent.
+const confirmationTokenTTL = 3 * 24 * time.Hour
+
 type Endpoint struct {
 	gorm.Model
 	Protocol   string
 	URI        string
 	Name       string
 	ResourceID uint
+
+	// Subscriptions of http and https endpoints receive no notifications
+	// until they are confirmed with the token sent to the endpoint, the token
+	// of confirmed subscriptions removes them by UnsubscribeURL
+	PendingConfirmation bool
+	Token               string
+	TokenExpiresAt      *time.Time
+
+	// Attributes of subscriptions
+	DeliveryPolicy     string `gorm:"type:text"`
+	RedrivePolicy      string `gorm:"type:varchar(1024)"`
+	FilterPolicy       string `gorm:"type:text"`
+	FilterPolicyScope  string
+	RawMessageDelivery bool
+}
+
+// NewEndpoint - creates a subscription of the endpoint, which is pending
+// confirmation when it has to be confirmed by the owner of the endpoint.
+func NewEndpoint(protocol, uri string) Endpoint {
+	name, _ := uuid.NewV4()
+	endpoint := Endpoint{
+		Protocol: protocol,
+		URI:      uri,
+		Name:     name.String(),
+		Token:    newToken(),
+	}
+
+	if protocol == "http" || protocol == "https" {
+		endpoint.PendingConfirmation = true
+		endpoint.ResetToken()
+	}
+
+	return endpoint
+}
+
+// newToken - returns a random token.
+func newToken() string {
+	buf := make([]byte, 64)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+// ResetToken - replaces the confirmation token with a new one, which expires
+// after confirmationTokenTTL.
+func (e *Endpoint) ResetToken() {
+	expiresAt := time.Now().Add(confirmationTokenTTL)
+
+	e.Token = newToken()
+	e.TokenExpiresAt = &expiresAt
+}
+
+// Confirm - confirms the subscription with the token sent to the endpoint.
+// The token is replaced with one which does not expire, for UnsubscribeURL.
+func (e *Endpoint) Confirm(token string) error {
+	if !e.PendingConfirmation {
+		return nil
+	}
+
+	if token == "" || token != e.Token || e.TokenExpiresAt == nil || time.Now().After(*e.TokenExpiresAt) {
+		return ErrInvalidToken
+	}
+
+	e.PendingConfirmation = false
+	e.Token = newToken()
+	e.TokenExpiresAt = nil
+	return nil
+}
+
+// ARN - returns the subscription ARN, or "PendingConfirmation" when it is not
+// confirmed yet.
+func (e Endpoint) ARN(topic Resource) string {
+	if e.PendingConfirmation {
+		return "PendingConfirmation"
+	}
+
+	return topic.ARN() + ":" + e.Name
+}
+
+// ConfirmedEndpoints - returns the endpoints of the topic which can receive
+// notifications, the endpoints must be loaded with the topic.
+func (r Resource) ConfirmedEndpoints() []Endpoint {
+	endpoints := []Endpoint{}
+	for _, endpoint := range r.Endpoints {
+		if !endpoint.PendingConfirmation {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	return endpoints
+}
+
+type subscriptionConfirmationBody struct {
+	Type         string
+	MessageId    string
+	Token        string
+	TopicArn     string
+	Message      string
+	SubscribeURL string
+	Timestamp    string
+
+	SignatureVersion string `json:",omitempty"`
+	Signature        string `json:",omitempty"`
+	SigningCertURL   string `json:",omitempty"`
+}
+
+// SendConfirmation - sends the SubscriptionConfirmation with the token and
+// the URL to confirm the subscription to the endpoint.
+func (e Endpoint) SendConfirmation(topic Resource) error {
+	serverConfig := config.GetServerConfig()
+	query := url.Values{}
+	query.Set("Action", "ConfirmSubscription")
+	query.Set("TopicArn", topic.ARN())
+	query.Set("Token", e.Token)
+
+	messageID, _ := uuid.NewV4()
+	body := subscriptionConfirmationBody{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    messageID.String(),
+		Token:        e.Token,
+		TopicArn:     topic.ARN(),
+		Message:      fmt.Sprintf("You have chosen to subscribe to the topic %s.\nTo confirm the subscription, visit the SubscribeURL included in this message.", topic.ARN()),
+		SubscribeURL: fmt.Sprintf("%s://%s/?%s", serverConfig.Scheme, serverConfig.Host, query.Encode()),
+		Timestamp:    time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+
+	signatureVersion := topic.EffectiveSignatureVersion()
+	signature, err := signMessage(signatureVersion,
+		"Message", body.Message,
+		"MessageId", body.MessageId,
+		"SubscribeURL", body.SubscribeURL,
+		"Timestamp", body.Timestamp,
+		"Token", body.Token,
+		"TopicArn", body.TopicArn,
+		"Type", body.Type,
+	)
+	if err != nil {
+		return err
+	}
+	if signature != "" {
+		body.SignatureVersion = signatureVersion
+		body.Signature = signature
+		body.SigningCertURL = SigningCertURL()
+	}
+
+	data, _ := json.Marshal(body)
+	return sendEvent(e, body.MessageId, string(data), map[string]string{
+		"x-amz-sns-message-type": body.Type,
+		"x-amz-sns-message-id":   body.MessageId,
+		"x-amz-sns-topic-arn":    body.TopicArn,
+	})
 }
 
 func ParseSubscription(s string) (*Endpoint, error) {
@@ -44,3 +211,39 @@ func ParseSubscription(s string) (*Endpoint, error) {
 		Name: tokens[6],
 	}, nil
 }
+
+// Subscription - an endpoint with the account and name of its topic.
+type Subscription struct {
+	Endpoint
+	TopicAccountID string
+	TopicName      string
+}
+
+// Topic - returns the topic of the subscription, with its account and name.
+func (s Subscription) Topic() Resource {
+	return Resource{
+		Service:   SNS,
+		AccountID: s.TopicAccountID,
+		Name:      s.TopicName,
+	}
+}
+
+// ListSubscriptions - returns up to limit subscriptions to the topics of the
+// account, or to the topic when topicID is not 0, with IDs after afterID in
+// the order of their IDs.
+func ListSubscriptions(accountID string, topicID uint, afterID uint, limit int) ([]Subscription, error) {
+	query := db.Model(&Endpoint{}).
+		Select("endpoints.*, resources.account_id AS topic_account_id, resources.name AS topic_name").
+		Joins("JOIN resources ON resources.id = endpoints.resource_id AND resources.deleted_at IS NULL").
+		Where("resources.service = ? AND resources.account_id = ?", SNS, accountID).
+		Where("endpoints.id > ?", afterID).
+		Order("endpoints.id").
+		Limit(limit)
+	if topicID != 0 {
+		query = query.Where("endpoints.resource_id = ?", topicID)
+	}
+
+	subscriptions := []Subscription{}
+	err := query.Scan(&subscriptions).Error
+	return subscriptions, err
+}
@@ -0,0 +1,52 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfirmEndpoint(t *testing.T) {
+	Convey("Given a subscription of an http endpoint", t, func() {
+		endpoint := models.NewEndpoint("http", "http://example.com/notify")
+
+		Convey("It should be pending confirmation", func() {
+			So(endpoint.PendingConfirmation, ShouldBeTrue)
+			So(endpoint.Token, ShouldNotBeEmpty)
+		})
+
+		Convey("When confirm it with another token", func() {
+			err := endpoint.Confirm("foobar")
+
+			Convey("It should stay pending confirmation", func() {
+				So(err, ShouldEqual, models.ErrInvalidToken)
+				So(endpoint.PendingConfirmation, ShouldBeTrue)
+			})
+		})
+
+		Convey("When confirm it with its token", func() {
+			token := endpoint.Token
+			err := endpoint.Confirm(token)
+
+			Convey("It should be confirmed with a new token to unsubscribe", func() {
+				So(err, ShouldBeNil)
+				So(endpoint.PendingConfirmation, ShouldBeFalse)
+				So(endpoint.Token, ShouldNotBeEmpty)
+				So(endpoint.Token, ShouldNotEqual, token)
+				So(endpoint.TokenExpiresAt, ShouldBeNil)
+			})
+		})
+	})
+
+	Convey("Given a subscription of an email endpoint", t, func() {
+		endpoint := models.NewEndpoint("email", "tester@example.com")
+
+		Convey("It should have a token to unsubscribe", func() {
+			So(endpoint.PendingConfirmation, ShouldBeFalse)
+			So(endpoint.Token, ShouldNotBeEmpty)
+			So(endpoint.TokenExpiresAt, ShouldBeNil)
+		})
+	})
+}
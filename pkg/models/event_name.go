@@ -0,0 +1,63 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+
+	"github.com/minio/minio/pkg/event"
+)
+
+// ObjectRemovedDeleteMarkerCreated - the event of deletes creating delete
+// markers in versioned buckets, which is not one of the event names of minio.
+const ObjectRemovedDeleteMarkerCreated = event.ObjectRemovedDelete + 1
+
+// EventNameString - returns the string representation of the event name.
+func EventNameString(name event.Name) string {
+	if name == ObjectRemovedDeleteMarkerCreated {
+		return "s3:ObjectRemoved:DeleteMarkerCreated"
+	}
+
+	return name.String()
+}
+
+// ParseEventName - parses the event name, including the ones minio does not
+// know.
+func ParseEventName(s string) (event.Name, error) {
+	if s == "s3:ObjectRemoved:DeleteMarkerCreated" {
+		return ObjectRemovedDeleteMarkerCreated, nil
+	}
+
+	return event.ParseName(s)
+}
+
+// ExpandEventName - returns the event names the abbreviated event name
+// stands for.
+func ExpandEventName(name event.Name) []event.Name {
+	if name == event.ObjectRemovedAll {
+		return []event.Name{event.ObjectRemovedDelete, ObjectRemovedDeleteMarkerCreated}
+	}
+
+	return name.Expand()
+}
+
+// MarshalEvent - encodes the S3 event to JSON with the string representation
+// of its name by EventNameString.
+func MarshalEvent(e event.Event) ([]byte, error) {
+	return json.Marshal(struct {
+		event.Event
+		EventName string `json:"eventName"`
+	}{e, EventNameString(e.EventName)})
+}
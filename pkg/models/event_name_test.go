@@ -0,0 +1,31 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+	"github.com/minio/minio/pkg/event"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeleteMarkerCreatedEventName(t *testing.T) {
+	Convey("Given the name of delete marker events", t, func() {
+		name, err := models.ParseEventName("s3:ObjectRemoved:DeleteMarkerCreated")
+		So(err, ShouldBeNil)
+
+		Convey("It should be one of the removed events", func() {
+			So(models.ExpandEventName(event.ObjectRemovedAll), ShouldContain, name)
+		})
+
+		Convey("Events should be encoded with the name", func() {
+			data, err := models.MarshalEvent(event.Event{EventName: name})
+			So(err, ShouldBeNil)
+
+			e := map[string]interface{}{}
+			json.Unmarshal(data, &e)
+			So(e["eventName"], ShouldEqual, "s3:ObjectRemoved:DeleteMarkerCreated")
+		})
+	})
+}
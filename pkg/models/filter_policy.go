@@ -0,0 +1,387 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const (
+	FilterPolicyScopeMessageAttributes = "MessageAttributes"
+	FilterPolicyScopeMessageBody       = "MessageBody"
+
+	maxFilterPolicyKeys         = 5
+	maxFilterPolicyCombinations = 150
+)
+
+// FilterPolicy - the FilterPolicy attribute of subscriptions. Each key holds
+// the conditions an attribute, or a property of the message body, must match
+// any of, and a message matches when all keys are matched. Policies on the
+// message body may hold nested properties.
+//
+// Conditions are exact strings, numbers and booleans, or objects with one of
+// prefix, suffix, equals-ignore-case, anything-but, numeric and exists.
+type FilterPolicy map[string]interface{}
+
+// ParseFilterPolicy - parses and validates the filter policy of the scope.
+func ParseFilterPolicy(s string, scope string) (FilterPolicy, error) {
+	policy := FilterPolicy{}
+	if err := json.Unmarshal([]byte(s), &policy); err != nil || len(policy) == 0 {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	keys, combinations, err := validateFilterPolicy(policy, scope == FilterPolicyScopeMessageBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if keys > maxFilterPolicyKeys || combinations > maxFilterPolicyCombinations {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	return policy, nil
+}
+
+// validateFilterPolicy - returns the number of keys with conditions and of
+// their combinations.
+func validateFilterPolicy(policy map[string]interface{}, nested bool) (keys int, combinations int, err error) {
+	combinations = 1
+	for _, value := range policy {
+		switch value := value.(type) {
+		case []interface{}:
+			if len(value) == 0 {
+				return 0, 0, ErrInvalidAttributeValue
+			}
+			for _, condition := range value {
+				if !isValidFilterCondition(condition) {
+					return 0, 0, ErrInvalidAttributeValue
+				}
+			}
+			keys++
+			combinations *= len(value)
+		case map[string]interface{}:
+			if !nested || len(value) == 0 {
+				return 0, 0, ErrInvalidAttributeValue
+			}
+			nestedKeys, nestedCombinations, err := validateFilterPolicy(value, nested)
+			if err != nil {
+				return 0, 0, err
+			}
+			keys += nestedKeys
+			combinations *= nestedCombinations
+		default:
+			return 0, 0, ErrInvalidAttributeValue
+		}
+	}
+
+	return keys, combinations, nil
+}
+
+func isValidFilterCondition(condition interface{}) bool {
+	switch condition := condition.(type) {
+	case string, float64, bool:
+		return true
+	case map[string]interface{}:
+		if len(condition) != 1 {
+			return false
+		}
+
+		for operator, operand := range condition {
+			switch operator {
+			case "prefix", "suffix", "equals-ignore-case":
+				_, ok := operand.(string)
+				return ok
+			case "exists":
+				_, ok := operand.(bool)
+				return ok
+			case "anything-but":
+				return isValidAnythingBut(operand)
+			case "numeric":
+				_, ok := parseNumericCondition(operand)
+				return ok
+			}
+		}
+	}
+
+	return false
+}
+
+func isValidAnythingBut(operand interface{}) bool {
+	switch operand := operand.(type) {
+	case string, float64:
+		return true
+	case []interface{}:
+		for _, value := range operand {
+			switch value.(type) {
+			case string, float64:
+			default:
+				return false
+			}
+		}
+		return len(operand) > 0
+	case map[string]interface{}:
+		prefix, ok := operand["prefix"].(string)
+		return ok && len(operand) == 1 && prefix != ""
+	}
+
+	return false
+}
+
+type numericComparison struct {
+	operator string
+	value    float64
+}
+
+// parseNumericCondition - parses the operand of numeric conditions, such as
+// ["=", 5] or [">", 0, "<=", 10].
+func parseNumericCondition(operand interface{}) ([]numericComparison, bool) {
+	list, ok := operand.([]interface{})
+	if !ok || len(list) == 0 || len(list)%2 != 0 || len(list) > 4 {
+		return nil, false
+	}
+
+	comparisons := []numericComparison{}
+	for i := 0; i < len(list); i += 2 {
+		operator, ok := list[i].(string)
+		if !ok {
+			return nil, false
+		}
+		value, ok := list[i+1].(float64)
+		if !ok {
+			return nil, false
+		}
+
+		switch operator {
+		case "=", "<", "<=", ">", ">=":
+		default:
+			return nil, false
+		}
+		comparisons = append(comparisons, numericComparison{operator, value})
+	}
+
+	// Only ranges combine two comparisons
+	if len(comparisons) == 2 && (!strings.HasPrefix(comparisons[0].operator, ">") || !strings.HasPrefix(comparisons[1].operator, "<")) {
+		return nil, false
+	}
+
+	return comparisons, true
+}
+
+// MatchAttributes - reports whether the message attributes match the policy.
+// Number attributes are compared as numbers, and String.Array attributes match
+// when any of their elements matches.
+func (p FilterPolicy) MatchAttributes(attributes map[string]MessageAttribute) bool {
+	for key, conditions := range p {
+		attribute, exists := attributes[key]
+		values := []interface{}{}
+		if exists {
+			values, exists = attributeFilterValues(attribute)
+		}
+
+		if !matchFilterConditions(conditions, values, exists) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func attributeFilterValues(attribute MessageAttribute) ([]interface{}, bool) {
+	switch {
+	case attribute.DataType == "String.Array":
+		values := []interface{}{}
+		if err := json.Unmarshal([]byte(attribute.StringValue), &values); err != nil {
+			return nil, false
+		}
+		return values, true
+	case strings.HasPrefix(attribute.DataType, "Number"):
+		value, err := strconv.ParseFloat(attribute.StringValue, 64)
+		if err != nil {
+			return nil, false
+		}
+		return []interface{}{value}, true
+	case strings.HasPrefix(attribute.DataType, "String"):
+		return []interface{}{attribute.StringValue}, true
+	}
+
+	// Binary attributes can not be matched
+	return nil, false
+}
+
+// MatchBody - reports whether the properties of the JSON message body match
+// the policy, arrays match when any of their elements matches.
+func (p FilterPolicy) MatchBody(body map[string]interface{}) bool {
+	for key, conditions := range p {
+		value, exists := body[key]
+
+		if nestedPolicy, ok := conditions.(map[string]interface{}); ok {
+			if !matchNestedFilterPolicy(FilterPolicy(nestedPolicy), value) {
+				return false
+			}
+			continue
+		}
+
+		values := []interface{}{value}
+		if list, ok := value.([]interface{}); ok {
+			values = list
+		}
+		if !matchFilterConditions(conditions, values, exists && value != nil) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchNestedFilterPolicy(policy FilterPolicy, value interface{}) bool {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return policy.MatchBody(value)
+	case []interface{}:
+		for _, element := range value {
+			if object, ok := element.(map[string]interface{}); ok && policy.MatchBody(object) {
+				return true
+			}
+		}
+	}
+
+	// Missing properties only match policies of conditions on nonexistence
+	return policy.MatchBody(map[string]interface{}{})
+}
+
+// matchFilterConditions - reports whether any of the values matches any of
+// the conditions.
+func matchFilterConditions(conditions interface{}, values []interface{}, exists bool) bool {
+	list, _ := conditions.([]interface{})
+	for _, condition := range list {
+		if object, ok := condition.(map[string]interface{}); ok {
+			if want, ok := object["exists"].(bool); ok {
+				if want == exists {
+					return true
+				}
+				continue
+			}
+		}
+
+		if !exists {
+			continue
+		}
+
+		for _, value := range values {
+			if matchFilterCondition(condition, value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func matchFilterCondition(condition interface{}, value interface{}) bool {
+	object, ok := condition.(map[string]interface{})
+	if !ok {
+		return condition == value
+	}
+
+	s, isString := value.(string)
+	for operator, operand := range object {
+		switch operator {
+		case "prefix":
+			return isString && strings.HasPrefix(s, operand.(string))
+		case "suffix":
+			return isString && strings.HasSuffix(s, operand.(string))
+		case "equals-ignore-case":
+			return isString && strings.EqualFold(s, operand.(string))
+		case "anything-but":
+			return matchAnythingBut(operand, value)
+		case "numeric":
+			n, ok := value.(float64)
+			if !ok {
+				return false
+			}
+			comparisons, _ := parseNumericCondition(operand)
+			for _, comparison := range comparisons {
+				if !comparison.match(n) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchAnythingBut(operand interface{}, value interface{}) bool {
+	switch operand := operand.(type) {
+	case []interface{}:
+		for _, excluded := range operand {
+			if excluded == value {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		s, ok := value.(string)
+		return !ok || !strings.HasPrefix(s, operand["prefix"].(string))
+	}
+
+	return operand != value
+}
+
+func (c numericComparison) match(n float64) bool {
+	switch c.operator {
+	case "=":
+		return n == c.value
+	case "<":
+		return n < c.value
+	case "<=":
+		return n <= c.value
+	case ">":
+		return n > c.value
+	case ">=":
+		return n >= c.value
+	}
+
+	return false
+}
+
+// MatchFilterPolicy - reports whether the notification should be delivered
+// to the subscription, which is when it has no filter policy or the policy
+// matches the attributes or the body of the notification by its scope.
+func (e Endpoint) MatchFilterPolicy(n Notification) bool {
+	if e.FilterPolicy == "" {
+		return true
+	}
+
+	policy, err := ParseFilterPolicy(e.FilterPolicy, e.FilterPolicyScope)
+	if err != nil {
+		return false
+	}
+
+	if e.FilterPolicyScope != FilterPolicyScopeMessageBody {
+		return policy.MatchAttributes(n.MessageAttributes)
+	}
+
+	// Bodies which are not JSON objects do not match
+	body := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(n.MessageFor(e.Protocol)), &body); err != nil {
+		return false
+	}
+
+	return policy.MatchBody(body)
+}
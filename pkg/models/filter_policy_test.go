@@ -0,0 +1,74 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMatchFilterPolicy(t *testing.T) {
+	Convey("Given a subscription filtering removed jpg objects larger than 1 MB by the message body", t, func() {
+		endpoint := models.Endpoint{
+			Protocol:          "http",
+			FilterPolicyScope: models.FilterPolicyScopeMessageBody,
+			FilterPolicy: `{
+				"eventName": [{"prefix": "s3:ObjectRemoved:"}],
+				"s3": {"object": {"key": [{"suffix": ".jpg"}], "size": [{"numeric": [">", 1048576]}]}}
+			}`,
+		}
+
+		Convey("Matching events should be delivered", func() {
+			n := models.Notification{Message: `{"eventName": "s3:ObjectRemoved:Delete", "s3": {"object": {"key": "photos/cat.jpg", "size": 2097152}}}`}
+			So(endpoint.MatchFilterPolicy(n), ShouldBeTrue)
+		})
+
+		Convey("Smaller objects should not be delivered", func() {
+			n := models.Notification{Message: `{"eventName": "s3:ObjectRemoved:Delete", "s3": {"object": {"key": "photos/cat.jpg", "size": 1024}}}`}
+			So(endpoint.MatchFilterPolicy(n), ShouldBeFalse)
+		})
+
+		Convey("Other events should not be delivered", func() {
+			n := models.Notification{Message: `{"eventName": "s3:ObjectCreated:Put", "s3": {"object": {"key": "photos/cat.jpg", "size": 2097152}}}`}
+			So(endpoint.MatchFilterPolicy(n), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a subscription filtering by message attributes", t, func() {
+		endpoint := models.Endpoint{
+			Protocol:     "sqs",
+			FilterPolicy: `{"bucket": [{"anything-but": ["logs"]}], "tags": ["red"], "trace": [{"exists": false}]}`,
+		}
+		n := models.Notification{MessageAttributes: map[string]models.MessageAttribute{
+			"bucket": {DataType: "String", StringValue: "photos"},
+			"tags":   {DataType: "String.Array", StringValue: `["blue", "red"]`},
+		}}
+
+		Convey("Matching messages should be delivered", func() {
+			So(endpoint.MatchFilterPolicy(n), ShouldBeTrue)
+		})
+
+		Convey("Messages of excluded values should not be delivered", func() {
+			n.MessageAttributes["bucket"] = models.MessageAttribute{DataType: "String", StringValue: "logs"}
+			So(endpoint.MatchFilterPolicy(n), ShouldBeFalse)
+		})
+
+		Convey("Messages without the attribute should not match anything-but", func() {
+			delete(n.MessageAttributes, "bucket")
+			So(endpoint.MatchFilterPolicy(n), ShouldBeFalse)
+		})
+	})
+
+	Convey("Invalid filter policies should be rejected", t, func() {
+		for _, policy := range []string{
+			`{}`,
+			`{"key": []}`,
+			`{"key": [{"numeric": ["<", 10, ">", 0]}]}`,
+			`{"s3": {"key": ["foo"]}}`,
+		} {
+			_, err := models.ParseFilterPolicy(policy, models.FilterPolicyScopeMessageAttributes)
+			So(err, ShouldEqual, models.ErrInvalidAttributeValue)
+		}
+	})
+}
@@ -0,0 +1,998 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/satori/go.uuid"
+
+	"github.com/inwinstack/kaoliang/pkg/config"
+)
+
+var (
+	ErrQueueFull              = errors.New("The queue has reached its maximum number of messages.")
+	ErrReceiptHandleIsInvalid = errors.New("The input receipt handle is invalid.")
+	ErrMessageNotInflight     = errors.New("The message referred to is not in flight.")
+	ErrNonExistentQueue       = errors.New("The specified queue does not exist for this wsdl version.")
+
+	ErrMissingMessageGroupID       = errors.New("The request must contain the parameter MessageGroupId.")
+	ErrMissingDeduplicationID      = errors.New("The queue should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly.")
+	ErrInvalidMessageGroupID       = errors.New("MessageGroupId can only include alphanumeric and punctuation characters. 1 to 128 in length.")
+	ErrInvalidDeduplicationID      = errors.New("MessageDeduplicationId can only include alphanumeric and punctuation characters. 1 to 128 in length.")
+	ErrInvalidParameterForStandard = errors.New("The request include parameter that is not valid for this queue type.")
+)
+
+// Messages sent to a FIFO queue with a deduplication ID already sent within
+// the interval are accepted but not delivered again.
+const deduplicationInterval = 5 * time.Minute
+
+var fifoIDRegexp = regexp.MustCompile("^[!-~]{1,128}$")
+
+// Message - a message stored in a queue.
+//
+// The queue list `sqs:<account>:<queue>:ids` only holds IDs of visible
+// messages, the messages themselves are kept in the
+// `sqs:<account>:<queue>:messages` hash. The `sqs:<account>:<queue>` list
+// held the bodies of messages before messages were stored by ID, bodies left
+// in it or pushed to it by older releases are still received first. Received messages are moved to the `sqs:<account>:<queue>:inflight`
+// sorted set scored by the time they become visible again, and delayed
+// messages wait in the `sqs:<account>:<queue>:delayed` sorted set scored by
+// the time they are delivered.
+//
+// Messages of a FIFO queue are kept by group in the
+// `sqs:<account>:<queue>:group_messages` sorted set until they are deleted, so
+// that messages of a group are always received in the order they were sent.
+// Received messages lock their group while they are in the inflight set, the
+// number of them is kept for each group in `sqs:<account>:<queue>:group_inflight`,
+// and groups which are not locked are kept in `sqs:<account>:<queue>:groups`.
+type Message struct {
+	ID            string `json:"id"`
+	Body          string `json:"body"`
+	SentTimestamp int64  `json:"sent_timestamp"`
+	ReceiptHandle string `json:"-"`
+	ReceiveCount  int64  `json:"-"`
+
+	MessageAttributes     map[string]MessageAttribute `json:"message_attributes,omitempty"`
+	SenderID              string                      `json:"sender_id,omitempty"`
+	FirstReceiveTimestamp int64                       `json:"first_receive_timestamp,omitempty"`
+
+	// Seconds the message is delayed before it can be received
+	DelaySeconds int `json:"-"`
+
+	// ARN of the queue the message was moved from to the dead-letter queue
+	DeadLetterSourceArn string `json:"dead_letter_source_arn,omitempty"`
+
+	// Attributes of messages sent to FIFO queues
+	MessageGroupID         string `json:"message_group_id,omitempty"`
+	MessageDeduplicationID string `json:"message_deduplication_id,omitempty"`
+	SequenceNumber         string `json:"sequence_number,omitempty"`
+}
+
+// NewMessage - creates a new message with the given body.
+func NewMessage(body string) Message {
+	messageID, _ := uuid.NewV4()
+
+	return Message{
+		ID:            messageID.String(),
+		Body:          body,
+		SentTimestamp: unixMilli(time.Now()),
+	}
+}
+
+// MD5OfBody - returns hex encoded MD5 digest of message body.
+func (m Message) MD5OfBody() string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(m.Body)))
+}
+
+// SetMessageGroup - sets the group and deduplication ID of a message sent to
+// the queue. Both are required for FIFO queues, except that the deduplication
+// ID defaults to the SHA-256 digest of the body when content-based
+// deduplication is enabled, and are not allowed for standard queues.
+func (r Resource) SetMessageGroup(msg *Message, groupID string, deduplicationID string) error {
+	if !r.FifoQueue {
+		if groupID != "" || deduplicationID != "" {
+			return ErrInvalidParameterForStandard
+		}
+		return nil
+	}
+
+	if groupID == "" {
+		return ErrMissingMessageGroupID
+	}
+	if !fifoIDRegexp.MatchString(groupID) {
+		return ErrInvalidMessageGroupID
+	}
+
+	if deduplicationID == "" {
+		if !r.ContentBasedDeduplication {
+			return ErrMissingDeduplicationID
+		}
+		deduplicationID = fmt.Sprintf("%x", sha256.Sum256([]byte(msg.Body)))
+	}
+	if !fifoIDRegexp.MatchString(deduplicationID) {
+		return ErrInvalidDeduplicationID
+	}
+
+	msg.MessageGroupID = groupID
+	msg.MessageDeduplicationID = deduplicationID
+	return nil
+}
+
+// NewEventMessage - creates a message of the bucket notification event, the
+// bucket, key and event name are attached as message attributes. Events sent
+// to a FIFO queue are grouped by object, so that events of an object are
+// received in order.
+func (r Resource) NewEventMessage(body string, bucketName string, objectName string, eventName string) Message {
+	msg := NewMessage(body)
+	msg.DelaySeconds = r.DelaySeconds
+	msg.MessageAttributes = map[string]MessageAttribute{
+		"bucket":    {DataType: "String", StringValue: bucketName},
+		"key":       {DataType: "String", StringValue: objectName},
+		"eventName": {DataType: "String", StringValue: eventName},
+	}
+	if r.FifoQueue {
+		groupID := bucketName + "/" + objectName
+		if !fifoIDRegexp.MatchString(groupID) {
+			groupID = fmt.Sprintf("%x", sha256.Sum256([]byte(groupID)))
+		}
+		msg.MessageGroupID = groupID
+		msg.MessageDeduplicationID = msg.ID
+	}
+
+	return msg
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// QueueKey - returns key of the queue, which prefixes the keys of its
+// messages. The Redis list under the key holds message bodies sent before
+// messages were stored by ID.
+func (r Resource) QueueKey() string {
+	return fmt.Sprintf("%s:%s:%s", r.Service, r.AccountID, r.Name)
+}
+
+// idsKey - returns key of the Redis list of IDs of visible messages in the
+// order they are received.
+func (r Resource) idsKey() string {
+	return r.QueueKey() + ":ids"
+}
+
+func (r Resource) messagesKey() string {
+	return r.QueueKey() + ":messages"
+}
+
+func (r Resource) sequenceKey() string {
+	return r.QueueKey() + ":sequence"
+}
+
+func (r Resource) deduplicationKey(deduplicationID string) string {
+	return r.QueueKey() + ":deduplication:" + deduplicationID
+}
+
+// Scripts of standard and FIFO queues start by telling which they are for.
+const (
+	standardQueueScript = "local fifo = false\n"
+	fifoQueueScript     = "local fifo = true\n"
+)
+
+// Messages of FIFO queues are kept in one sorted set as "group sequence id"
+// members, ordered by group and sequence number, so that the messages of a
+// group are a range of members since group IDs have no spaces. Groups without
+// received messages whose first message is not delayed are available, and
+// kept in a sorted set by the sequence number of their first message.
+const fifoGroupsScript = `
+local function memberOf(id, message)
+	return (message.message_group_id or '') .. ' ' .. (message.sequence_number or '') .. ' ' .. id
+end
+
+local function groupMember(group, offset)
+	return redis.call('ZRANGEBYLEX', KEYS[10], '[' .. group .. ' ', '(' .. group .. '!', 'LIMIT', offset, 1)[1]
+end
+
+local function refreshGroup(group)
+	local head = groupMember(group, 0)
+	if not head or redis.call('HEXISTS', KEYS[12], group) == 1 then
+		redis.call('ZREM', KEYS[11], group)
+		return
+	end
+	local _, _, sequence, id = string.find(head, ' (%S*) (%S+)$')
+	if redis.call('ZSCORE', KEYS[6], id) then
+		redis.call('ZREM', KEYS[11], group)
+	else
+		redis.call('ZADD', KEYS[11], tonumber(sequence) or 0, group)
+	end
+end
+
+local function addToGroup(id, message)
+	local group = message.message_group_id or ''
+	if redis.call('ZSCORE', KEYS[3], id) then
+		redis.call('HINCRBY', KEYS[12], group, 1)
+	end
+	redis.call('ZADD', KEYS[10], 0, memberOf(id, message))
+	refreshGroup(group)
+end
+
+local function releaseGroup(group)
+	if redis.call('HINCRBY', KEYS[12], group, -1) <= 0 then
+		redis.call('HDEL', KEYS[12], group)
+	end
+	refreshGroup(group)
+end
+
+local function removeFromGroup(id)
+	local value = redis.call('HGET', KEYS[2], id)
+	if not value then
+		return
+	end
+	local message = cjson.decode(value)
+	redis.call('ZREM', KEYS[10], memberOf(id, message))
+	redis.call('ZREM', KEYS[6], id)
+	if redis.call('ZREM', KEYS[3], id) == 1 then
+		releaseGroup(message.message_group_id or '')
+	else
+		refreshGroup(message.message_group_id or '')
+	end
+end
+`
+
+// Messages are removed with all their state. Messages kept longer than the
+// retention period are dropped, at most 100 at a time, and so are the oldest
+// messages to make room for new ones when the queue is full and the overflow
+// policy is drop-oldest. Every dropped message is counted.
+//
+// Removed messages of standard queues are not searched in the list, they are
+// marked removed and their entries are dropped when they are received.
+const removeMessagesScript = `
+local function listed(id)
+	return not (redis.call('ZSCORE', KEYS[3], id) or redis.call('ZSCORE', KEYS[6], id))
+end
+
+local function removeMessage(id, inList)
+	if fifo then
+		removeFromGroup(id)
+	elseif inList then
+		redis.call('SADD', KEYS[9], id)
+	end
+	redis.call('HDEL', KEYS[2], id)
+	redis.call('ZREM', KEYS[3], id)
+	redis.call('HDEL', KEYS[4], id)
+	redis.call('HDEL', KEYS[5], id)
+	redis.call('ZREM', KEYS[6], id)
+	redis.call('ZREM', KEYS[7], id)
+end
+
+local function makeRoom(maxDepth, policy)
+	if maxDepth <= 0 or redis.call('HLEN', KEYS[2]) < maxDepth then
+		return true
+	end
+	local oldest = redis.call('ZRANGE', KEYS[7], 0, 0)[1]
+	if policy ~= 'drop-oldest' or not oldest then
+		return false
+	end
+	removeMessage(oldest, listed(oldest))
+	redis.call('INCR', KEYS[8])
+	return true
+end
+
+if tonumber(ARGV[2]) > 0 then
+	local expired = redis.call('ZRANGEBYSCORE', KEYS[7], '-inf', tonumber(ARGV[1]) - tonumber(ARGV[2]), 'LIMIT', 0, 100)
+	for _, id in ipairs(expired) do
+		removeMessage(id, listed(id))
+	end
+	if #expired > 0 then
+		redis.call('INCRBY', KEYS[8], #expired)
+	end
+end
+`
+
+// Sends messages to a standard queue, and returns the number of sent messages.
+var sendScript = redis.NewScript(standardQueueScript + fifoGroupsScript + removeMessagesScript + `
+local sent = 0
+for i = 5, #ARGV, 3 do
+	if makeRoom(tonumber(ARGV[3]), ARGV[4]) then
+		local id = ARGV[i]
+		redis.call('HSET', KEYS[2], id, ARGV[i + 1])
+		redis.call('ZADD', KEYS[7], ARGV[1], id)
+		if tonumber(ARGV[i + 2]) > 0 then
+			redis.call('ZADD', KEYS[6], ARGV[i + 2], id)
+		else
+			redis.call('RPUSH', KEYS[1], id)
+		end
+		sent = sent + 1
+	end
+end
+return sent
+`)
+
+// Sends a message to a FIFO queue unless its deduplication ID is seen within
+// the interval, and returns the ID and sequence number of the message.
+var sendFifoScript = redis.NewScript(fifoQueueScript + fifoGroupsScript + removeMessagesScript + `
+local sent = redis.call('GET', KEYS[13])
+if sent then
+	return sent
+end
+if not makeRoom(tonumber(ARGV[3]), ARGV[4]) then
+	return false
+end
+local message = cjson.decode(ARGV[6])
+message.sequence_number = string.format('%020d', redis.call('INCR', KEYS[14]))
+redis.call('HSET', KEYS[2], ARGV[5], cjson.encode(message))
+redis.call('ZADD', KEYS[7], ARGV[1], ARGV[5])
+if tonumber(ARGV[8]) > 0 then
+	redis.call('ZADD', KEYS[6], ARGV[8], ARGV[5])
+end
+addToGroup(ARGV[5], message)
+sent = ARGV[5] .. ':' .. message.sequence_number
+redis.call('SET', KEYS[13], sent, 'EX', ARGV[7])
+return sent
+`)
+
+// sendArgs - returns the arguments all sending scripts start with. The
+// maximum depth and overflow policy are the same for every queue of the
+// server.
+func (r Resource) sendArgs() []interface{} {
+	config := config.GetServerConfig()
+	return []interface{}{
+		unixMilli(time.Now()),
+		int64(r.MessageRetentionPeriod) * 1000,
+		config.QueueMaxDepth,
+		config.QueueOverflowPolicy,
+	}
+}
+
+// SendMessages - appends messages to the end of the queue and returns the sent
+// messages. Messages sent to a FIFO queue get their sequence numbers, and
+// duplicates get the ID of the message sent before. When an error occurs, or
+// the queue is full, the messages sent before are returned.
+func (r Resource) SendMessages(msgs ...Message) ([]Message, error) {
+	if r.FifoQueue {
+		return r.sendFifoMessages(msgs)
+	}
+
+	args := r.sendArgs()
+	for _, msg := range msgs {
+		value, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, msg.ID, value, msg.deliveryTime())
+	}
+
+	client := GetCache()
+	result, err := sendScript.Run(client, r.keys(), args...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sent := msgs[:result.(int64)]
+	client.Publish(r.notifyKey(), len(sent))
+	if len(sent) < len(msgs) {
+		return sent, ErrQueueFull
+	}
+
+	return sent, nil
+}
+
+// deliveryTime - returns the time in milliseconds the delayed message is
+// delivered, or zero when it is not delayed.
+func (m Message) deliveryTime() int64 {
+	if m.DelaySeconds <= 0 {
+		return 0
+	}
+
+	return m.SentTimestamp + int64(m.DelaySeconds)*1000
+}
+
+func (r Resource) sendFifoMessages(msgs []Message) ([]Message, error) {
+	client := GetCache()
+	sent := []Message{}
+	for _, msg := range msgs {
+		value, err := json.Marshal(msg)
+		if err != nil {
+			return sent, err
+		}
+
+		keys := append(r.keys(), r.deduplicationKey(msg.MessageDeduplicationID), r.sequenceKey())
+		args := append(r.sendArgs(), msg.ID, value, int64(deduplicationInterval/time.Second), msg.deliveryTime())
+		result, err := sendFifoScript.Run(client, keys, args...).Result()
+		if err == redis.Nil {
+			err = ErrQueueFull
+		}
+		if err != nil {
+			client.Publish(r.notifyKey(), len(sent))
+			return sent, err
+		}
+
+		tokens := strings.SplitN(result.(string), ":", 2)
+		msg.ID, msg.SequenceNumber = tokens[0], tokens[1]
+		sent = append(sent, msg)
+	}
+	client.Publish(r.notifyKey(), len(sent))
+
+	return sent, nil
+}
+
+func (r Resource) inflightKey() string {
+	return r.QueueKey() + ":inflight"
+}
+
+func (r Resource) receiptsKey() string {
+	return r.QueueKey() + ":receipts"
+}
+
+func (r Resource) receiveCountsKey() string {
+	return r.QueueKey() + ":receive_counts"
+}
+
+// delayedKey - returns key of the sorted set holding delayed messages scored
+// by the time they are delivered.
+func (r Resource) delayedKey() string {
+	return r.QueueKey() + ":delayed"
+}
+
+// sentKey - returns key of the sorted set holding all messages scored by the
+// time they are sent, messages are expired and dropped in this order.
+func (r Resource) sentKey() string {
+	return r.QueueKey() + ":sent"
+}
+
+// droppedKey - returns key of the number of messages dropped because they are
+// expired or the queue is full.
+func (r Resource) droppedKey() string {
+	return r.QueueKey() + ":dropped"
+}
+
+// removedKey - returns key of the set of removed messages which are still in
+// the list.
+func (r Resource) removedKey() string {
+	return r.QueueKey() + ":removed"
+}
+
+// groupMessagesKey - returns key of the sorted set holding the messages of a
+// FIFO queue by group and sequence number.
+func (r Resource) groupMessagesKey() string {
+	return r.QueueKey() + ":group_messages"
+}
+
+// groupsKey - returns key of the sorted set of groups of a FIFO queue which
+// messages can be received from.
+func (r Resource) groupsKey() string {
+	return r.QueueKey() + ":groups"
+}
+
+// groupInflightKey - returns key of the number of received messages of each
+// group of a FIFO queue.
+func (r Resource) groupInflightKey() string {
+	return r.QueueKey() + ":group_inflight"
+}
+
+// notifyKey - returns the channel that is published to when messages are sent
+// to the queue, long polling receivers subscribe to it.
+func (r Resource) notifyKey() string {
+	return r.QueueKey() + ":notify"
+}
+
+func (r Resource) keys() []string {
+	return []string{
+		r.idsKey(), r.messagesKey(), r.inflightKey(), r.receiptsKey(), r.receiveCountsKey(),
+		r.delayedKey(), r.sentKey(), r.droppedKey(), r.removedKey(),
+		r.groupMessagesKey(), r.groupsKey(), r.groupInflightKey(),
+	}
+}
+
+// fifoArg - tells scripts whether the queue is a FIFO queue.
+func (r Resource) fifoArg() int {
+	if r.FifoQueue {
+		return 1
+	}
+	return 0
+}
+
+func newReceiptHandle(messageID string, nonce string) string {
+	return base64.URLEncoding.EncodeToString([]byte(messageID + ":" + nonce))
+}
+
+func parseReceiptHandle(receiptHandle string) (messageID string, nonce string, err error) {
+	data, err := base64.URLEncoding.DecodeString(receiptHandle)
+	if err != nil {
+		return "", "", ErrReceiptHandleIsInvalid
+	}
+
+	tokens := strings.Split(string(data), ":")
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		return "", "", ErrReceiptHandleIsInvalid
+	}
+
+	return tokens[0], tokens[1], nil
+}
+
+// Messages whose visibility timeout is expired are put back to the head of
+// the queue before receiving.
+const requeueExpiredScript = `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', ARGV[1])
+for i = #expired, 1, -1 do
+	redis.call('LPUSH', KEYS[1], expired[i])
+	redis.call('HDEL', KEYS[4], expired[i])
+end
+if #expired > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', ARGV[1])
+end
+`
+
+// The time a message is received for the first time is stored in its record.
+const setFirstReceiveTimestampScript = `
+local function setFirstReceiveTimestamp(id, value)
+	local message = cjson.decode(value)
+	message.first_receive_timestamp = tonumber(ARGV[1])
+	value = cjson.encode(message)
+	redis.call('HSET', KEYS[2], id, value)
+	return value
+end
+`
+
+// Messages are moved to the dead-letter queue with the time they were sent,
+// so that they are expired by the retention period of the dead-letter queue.
+// Messages of standard queues are moved once they are taken from the list.
+const moveToDeadLetterQueueScript = `
+local function moveToDeadLetterQueue(id, message)
+	message.dead_letter_source_arn = ARGV[6]
+	removeMessage(id, false)
+	redis.call('HSET', KEYS[14], id, cjson.encode(message))
+	redis.call('ZADD', KEYS[15], message.sent_timestamp, id)
+	redis.call('RPUSH', KEYS[13], id)
+end
+`
+
+// Messages received more than maxReceiveCount times are moved to the
+// dead-letter queue instead of being delivered.
+var receiveScript = redis.NewScript(standardQueueScript + fifoGroupsScript + removeMessagesScript + deliverDelayedScript + requeueExpiredScript +
+	setFirstReceiveTimestampScript + moveToDeadLetterQueueScript + `
+local now = tonumber(ARGV[1])
+local deadline = now + tonumber(ARGV[3])
+local maxReceiveCount = tonumber(ARGV[5])
+local result = {}
+while #result < tonumber(ARGV[4]) do
+	local id = redis.call('LPOP', KEYS[16]) or redis.call('LPOP', KEYS[1])
+	if not id then
+		break
+	end
+
+	local nonce = ARGV[7 + #result]
+	local value = redis.call('HGET', KEYS[2], id)
+	local removed = not value and redis.call('SREM', KEYS[9], id) == 1
+	if not value and not removed then
+		-- entries pushed before messages were stored in the hash are raw bodies
+		value = cjson.encode({id = nonce, body = id, sent_timestamp = now})
+		id = nonce
+		redis.call('HSET', KEYS[2], id, value)
+		redis.call('ZADD', KEYS[7], now, id)
+	end
+
+	local count = tonumber(redis.call('HGET', KEYS[5], id) or '0')
+	if removed then
+		-- the entry of the removed message is dropped
+	elseif maxReceiveCount > 0 and count >= maxReceiveCount then
+		moveToDeadLetterQueue(id, cjson.decode(value))
+	else
+		redis.call('ZADD', KEYS[3], deadline, id)
+		redis.call('HSET', KEYS[4], id, nonce)
+		count = redis.call('HINCRBY', KEYS[5], id, 1)
+		if count == 1 then
+			value = setFirstReceiveTimestamp(id, value)
+		end
+		table.insert(result, {id, value, nonce, count})
+	end
+end
+return result
+`)
+
+// Delayed messages are appended to the queue once they are due.
+const deliverDelayedScript = `
+local due = redis.call('ZRANGEBYSCORE', KEYS[6], '-inf', ARGV[1])
+for _, id in ipairs(due) do
+	redis.call('RPUSH', KEYS[1], id)
+end
+if #due > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[6], '-inf', ARGV[1])
+end
+`
+
+// Received messages of FIFO queues stay in their groups, only their receipts
+// are removed when their visibility timeout is expired, and their groups are
+// available again. Groups whose first message is due are available again.
+const releaseExpiredScript = `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', ARGV[1])
+for _, id in ipairs(expired) do
+	redis.call('HDEL', KEYS[4], id)
+	redis.call('ZREM', KEYS[3], id)
+	local value = redis.call('HGET', KEYS[2], id)
+	if value then
+		releaseGroup(cjson.decode(value).message_group_id or '')
+	end
+end
+local due = redis.call('ZRANGEBYSCORE', KEYS[6], '-inf', ARGV[1])
+if #due > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[6], '-inf', ARGV[1])
+end
+for _, id in ipairs(due) do
+	local value = redis.call('HGET', KEYS[2], id)
+	if value then
+		refreshGroup(cjson.decode(value).message_group_id or '')
+	end
+end
+`
+
+// Messages pushed to the lists of a FIFO queue, before messages were kept in
+// groups or when they are moved to the dead-letter queue, are added to their
+// groups before receiving.
+const importListedScript = `
+for _, key in ipairs({KEYS[16], KEYS[1]}) do
+	for _, id in ipairs(redis.call('LRANGE', key, 0, -1)) do
+		local value = redis.call('HGET', KEYS[2], id)
+		if value then
+			addToGroup(id, cjson.decode(value))
+		else
+			redis.call('SREM', KEYS[9], id)
+		end
+	end
+	redis.call('DEL', key)
+end
+`
+
+// Messages are received in order from the available groups of a FIFO queue,
+// by the order of their first messages. Messages of a group are received up
+// to its first delayed message, and the group is not available until the
+// received messages are deleted or visible again.
+var receiveFifoScript = redis.NewScript(fifoQueueScript + fifoGroupsScript + removeMessagesScript + releaseExpiredScript +
+	importListedScript + setFirstReceiveTimestampScript + moveToDeadLetterQueueScript + `
+local now = tonumber(ARGV[1])
+local deadline = now + tonumber(ARGV[3])
+local max = tonumber(ARGV[4])
+local maxReceiveCount = tonumber(ARGV[5])
+local visited = {}
+local result = {}
+while #result < max do
+	local group = redis.call('ZRANGE', KEYS[11], 0, 0)[1]
+	if not group or visited[group] then
+		break
+	end
+	visited[group] = true
+
+	-- received messages stay in the group, the next one is after them
+	local offset = 0
+	while #result < max do
+		local member = groupMember(group, offset)
+		if not member then
+			break
+		end
+
+		local id = string.match(member, '(%S+)$')
+		local value = redis.call('HGET', KEYS[2], id)
+		if not value then
+			redis.call('ZREM', KEYS[10], member)
+		elseif redis.call('ZSCORE', KEYS[6], id) then
+			break
+		else
+			local count = tonumber(redis.call('HGET', KEYS[5], id) or '0')
+			if maxReceiveCount > 0 and count >= maxReceiveCount then
+				moveToDeadLetterQueue(id, cjson.decode(value))
+			else
+				local nonce = ARGV[7 + #result]
+				redis.call('HINCRBY', KEYS[12], group, 1)
+				redis.call('ZADD', KEYS[3], deadline, id)
+				redis.call('HSET', KEYS[4], id, nonce)
+				count = redis.call('HINCRBY', KEYS[5], id, 1)
+				if count == 1 then
+					value = setFirstReceiveTimestamp(id, value)
+				end
+				table.insert(result, {id, value, nonce, count})
+				offset = offset + 1
+			end
+		end
+	end
+	refreshGroup(group)
+end
+return result
+`)
+
+// Moves the message at the head of the queue to the tail of another queue,
+// where it is no longer from the dead-letter source queue.
+var moveScript = redis.NewScript(`
+if redis.call('LINDEX', KEYS[1], 0) ~= ARGV[1] then
+	return 0
+end
+redis.call('LPOP', KEYS[1])
+local value = redis.call('HGET', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+redis.call('ZREM', KEYS[7], ARGV[1])
+if value then
+	local message = cjson.decode(value)
+	message.dead_letter_source_arn = nil
+	redis.call('HSET', KEYS[6], ARGV[1], cjson.encode(message))
+	redis.call('ZADD', KEYS[8], message.sent_timestamp, ARGV[1])
+	redis.call('RPUSH', KEYS[5], ARGV[1])
+else
+	redis.call('SREM', KEYS[9], ARGV[1])
+end
+return 1
+`)
+
+var deleteScript = redis.NewScript(fifoGroupsScript + `
+if redis.call('HGET', KEYS[4], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+if ARGV[3] == '1' then
+	removeFromGroup(ARGV[1])
+end
+redis.call('ZREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[4], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+redis.call('ZREM', KEYS[7], ARGV[1])
+return 1
+`)
+
+var changeVisibilityScript = redis.NewScript(fifoGroupsScript + `
+if redis.call('HGET', KEYS[4], ARGV[1]) ~= ARGV[2] or not redis.call('ZSCORE', KEYS[3], ARGV[1]) then
+	return 0
+end
+if tonumber(ARGV[4]) == 0 then
+	redis.call('ZREM', KEYS[3], ARGV[1])
+	redis.call('HDEL', KEYS[4], ARGV[1])
+	if ARGV[5] == '1' then
+		releaseGroup(cjson.decode(redis.call('HGET', KEYS[2], ARGV[1])).message_group_id or '')
+	else
+		redis.call('LPUSH', KEYS[1], ARGV[1])
+	end
+else
+	redis.call('ZADD', KEYS[3], tonumber(ARGV[3]) + tonumber(ARGV[4]), ARGV[1])
+end
+return 1
+`)
+
+// ReceiveMessages - receives at most max messages from the head of the queue.
+// Received messages stay invisible for the visibility timeout and come back
+// to the queue unless they are deleted.
+func (r Resource) ReceiveMessages(max int, visibilityTimeout time.Duration) ([]Message, error) {
+	// Without a dead-letter queue the keys of the queue itself are passed,
+	// they are never used since maxReceiveCount is zero.
+	deadLetterQueue := r
+	maxReceiveCount := 0
+	if policy, err := ParseRedrivePolicy(r.RedrivePolicy); err == nil {
+		target, _ := ParseARN(policy.DeadLetterTargetArn)
+		deadLetterQueue = *target
+		maxReceiveCount = policy.MaxReceiveCount
+	}
+
+	args := []interface{}{
+		unixMilli(time.Now()),
+		int64(r.MessageRetentionPeriod) * 1000,
+		int64(visibilityTimeout / time.Millisecond),
+		max,
+		maxReceiveCount,
+		r.ARN(),
+	}
+	for i := 0; i < max; i++ {
+		nonce, _ := uuid.NewV4()
+		args = append(args, nonce.String())
+	}
+
+	keys := append(r.keys(), deadLetterQueue.idsKey(), deadLetterQueue.messagesKey(), deadLetterQueue.sentKey(), r.QueueKey())
+
+	script := receiveScript
+	if r.FifoQueue {
+		script = receiveFifoScript
+	}
+
+	client := GetCache()
+	result, err := script.Run(client, keys, args...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []Message{}
+	for _, item := range result.([]interface{}) {
+		fields := item.([]interface{})
+		msg := Message{}
+		if err := json.Unmarshal([]byte(fields[1].(string)), &msg); err != nil {
+			return nil, err
+		}
+		msg.ID = fields[0].(string)
+		msg.ReceiptHandle = newReceiptHandle(msg.ID, fields[2].(string))
+		msg.ReceiveCount = fields[3].(int64)
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// WaitMessages - receives messages like ReceiveMessages, but waits up to wait
+// for messages to arrive when the queue is empty. It returns no messages as
+// soon as ctx is done.
+func (r Resource) WaitMessages(ctx context.Context, max int, visibilityTimeout time.Duration, wait time.Duration) ([]Message, error) {
+	msgs, err := r.ReceiveMessages(max, visibilityTimeout)
+	if err != nil || len(msgs) > 0 || wait <= 0 {
+		return msgs, err
+	}
+
+	client := GetCache()
+	pubsub := client.Subscribe(r.notifyKey())
+	defer pubsub.Close()
+
+	// Closing the subscription stops waiting at once when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pubsub.Close()
+		case <-done:
+		}
+	}()
+
+	deadline := time.Now().Add(wait)
+	for {
+		if ctx.Err() != nil {
+			return []Message{}, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return []Message{}, nil
+		}
+
+		// Wake up at least every second to pick up messages whose visibility
+		// timeout expired or which are due.
+		if remaining > time.Second {
+			remaining = time.Second
+		}
+		pubsub.ReceiveTimeout(remaining)
+		if ctx.Err() != nil {
+			return []Message{}, nil
+		}
+
+		msgs, err := r.ReceiveMessages(max, visibilityTimeout)
+		if err != nil || len(msgs) > 0 {
+			return msgs, err
+		}
+	}
+}
+
+// DeleteMessage - deletes the message received with the receipt handle.
+// Nothing is deleted when the message has been received again since then.
+func (r Resource) DeleteMessage(receiptHandle string) error {
+	messageID, nonce, err := parseReceiptHandle(receiptHandle)
+	if err != nil {
+		return err
+	}
+
+	client := GetCache()
+	return deleteScript.Run(client, r.keys(), messageID, nonce, r.fifoArg()).Err()
+}
+
+// ChangeMessageVisibility - makes the message received with the receipt
+// handle visible again after timeout, counting from now.
+func (r Resource) ChangeMessageVisibility(receiptHandle string, timeout time.Duration) error {
+	messageID, nonce, err := parseReceiptHandle(receiptHandle)
+	if err != nil {
+		return err
+	}
+
+	client := GetCache()
+	changed, err := changeVisibilityScript.Run(client, r.keys(), messageID, nonce, unixMilli(time.Now()), int64(timeout/time.Millisecond), r.fifoArg()).Result()
+	if err != nil {
+		return err
+	}
+
+	if changed.(int64) == 0 {
+		return ErrMessageNotInflight
+	}
+
+	if timeout == 0 {
+		client.Publish(r.notifyKey(), 1)
+	}
+
+	return nil
+}
+
+// PurgeMessages - deletes all messages of the queue, including the received
+// ones, and resets the number of dropped messages. Sequence numbers of FIFO
+// queues keep increasing.
+func (r Resource) PurgeMessages() error {
+	client := GetCache()
+	return client.Del(append(r.keys(), r.QueueKey())...).Err()
+}
+
+// DeleteMessages - deletes all messages and state of the queue when it is
+// deleted. Deduplication IDs are kept until their interval is over.
+func (r Resource) DeleteMessages() error {
+	client := GetCache()
+	return client.Del(append(r.keys(), r.QueueKey(), r.sequenceKey())...).Err()
+}
+
+// RedriveMessages - moves the visible messages of the dead-letter queue to
+// destination, or back to the queues they were moved from when destination is
+// nil, and returns the number of moved messages. Messages whose source queue
+// is unknown are kept in the dead-letter queue, and moving stops with
+// ErrNonExistentQueue at messages whose source queue has been deleted.
+func (r Resource) RedriveMessages(destination *Resource) (int, error) {
+	client := GetCache()
+	length, err := client.LLen(r.idsKey()).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	exists := map[string]bool{}
+	moved := 0
+	for i := int64(0); i < length; i++ {
+		messageID, err := client.LIndex(r.idsKey(), 0).Result()
+		if err == redis.Nil {
+			break
+		} else if err != nil {
+			return moved, err
+		}
+
+		target := r
+		if destination != nil {
+			target = *destination
+		} else {
+			msg := Message{}
+			value, _ := client.HGet(r.messagesKey(), messageID).Result()
+			json.Unmarshal([]byte(value), &msg)
+			if source, err := ParseARN(msg.DeadLetterSourceArn); err == nil {
+				target = *source
+			}
+		}
+
+		if _, ok := exists[target.QueueKey()]; !ok {
+			exists[target.QueueKey()] = !db.Where(Resource{Service: SQS, AccountID: target.AccountID, Name: target.Name}).First(&Resource{}).RecordNotFound()
+		}
+		if !exists[target.QueueKey()] {
+			return moved, ErrNonExistentQueue
+		}
+
+		keys := []string{
+			r.idsKey(), r.messagesKey(), r.receiptsKey(), r.receiveCountsKey(),
+			target.idsKey(), target.messagesKey(),
+			r.sentKey(), target.sentKey(), r.removedKey(),
+		}
+		ok, err := moveScript.Run(client, keys, messageID).Result()
+		if err != nil {
+			return moved, err
+		}
+
+		if ok.(int64) == 1 && target.QueueKey() != r.QueueKey() {
+			client.Publish(target.notifyKey(), 1)
+			moved++
+		}
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,126 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MessageAttribute - a typed attribute sent with a message. The data type is
+// String, Number or Binary, optionally followed by a custom label like
+// `Number.float`.
+type MessageAttribute struct {
+	DataType    string `json:"data_type"`
+	StringValue string `json:"string_value,omitempty"`
+	BinaryValue []byte `json:"binary_value,omitempty"`
+}
+
+// IsBinary - reports whether the attribute holds a binary value.
+func (a MessageAttribute) IsBinary() bool {
+	return strings.Split(a.DataType, ".")[0] == "Binary"
+}
+
+// MessageSystemAttributeNames - names of the system attributes returned for a
+// received message.
+var MessageSystemAttributeNames = []string{
+	"ApproximateFirstReceiveTimestamp",
+	"ApproximateReceiveCount",
+	"DeadLetterQueueSourceArn",
+	"MessageDeduplicationId",
+	"MessageGroupId",
+	"SenderId",
+	"SentTimestamp",
+	"SequenceNumber",
+}
+
+// MD5OfMessageAttributes - returns hex encoded MD5 digest of the message
+// attributes computed like SQS does, or an empty string when there are none.
+func (m Message) MD5OfMessageAttributes() string {
+	if len(m.MessageAttributes) == 0 {
+		return ""
+	}
+
+	names := []string{}
+	for name := range m.MessageAttributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := md5.New()
+	writeString := func(b []byte) {
+		length := make([]byte, 4)
+		binary.BigEndian.PutUint32(length, uint32(len(b)))
+		hash.Write(length)
+		hash.Write(b)
+	}
+	for _, name := range names {
+		attribute := m.MessageAttributes[name]
+		writeString([]byte(name))
+		writeString([]byte(attribute.DataType))
+		if attribute.IsBinary() {
+			hash.Write([]byte{2})
+			writeString(attribute.BinaryValue)
+		} else {
+			hash.Write([]byte{1})
+			writeString([]byte(attribute.StringValue))
+		}
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+// Size - returns the size of the message counted against the maximum message
+// size, including the names, types and values of its attributes.
+func (m Message) Size() int {
+	size := len(m.Body)
+	for name, attribute := range m.MessageAttributes {
+		size += len(name) + len(attribute.DataType) + len(attribute.StringValue) + len(attribute.BinaryValue)
+	}
+
+	return size
+}
+
+// SystemAttributes - returns the system attributes of a received message,
+// attributes without value are omitted.
+func (m Message) SystemAttributes() map[string]string {
+	attributes := map[string]string{
+		"ApproximateReceiveCount": strconv.FormatInt(m.ReceiveCount, 10),
+		"SentTimestamp":           strconv.FormatInt(m.SentTimestamp, 10),
+	}
+
+	if m.FirstReceiveTimestamp != 0 {
+		attributes["ApproximateFirstReceiveTimestamp"] = strconv.FormatInt(m.FirstReceiveTimestamp, 10)
+	}
+
+	optional := map[string]string{
+		"DeadLetterQueueSourceArn": m.DeadLetterSourceArn,
+		"MessageDeduplicationId":   m.MessageDeduplicationID,
+		"MessageGroupId":           m.MessageGroupID,
+		"SenderId":                 m.SenderID,
+		"SequenceNumber":           m.SequenceNumber,
+	}
+	for name, value := range optional {
+		if value != "" {
+			attributes[name] = value
+		}
+	}
+
+	return attributes
+}
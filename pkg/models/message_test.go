@@ -0,0 +1,437 @@
+package models_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/inwinstack/kaoliang/pkg/config"
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetMessageGroup(t *testing.T) {
+	Convey("Given a FIFO queue with content-based deduplication", t, func() {
+		queue := models.NewQueue("tester", "foobar.fifo")
+		queue.ContentBasedDeduplication = true
+		msg := models.NewMessage("foobar")
+
+		Convey("When send a message without deduplication ID", func() {
+			err := queue.SetMessageGroup(&msg, "group", "")
+
+			Convey("The deduplication ID should be SHA-256 of the body", func() {
+				So(err, ShouldBeNil)
+				So(msg.MessageGroupID, ShouldEqual, "group")
+				So(msg.MessageDeduplicationID, ShouldEqual, "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2")
+			})
+		})
+
+		Convey("When send a message without group ID", func() {
+			err := queue.SetMessageGroup(&msg, "", "foobar")
+
+			Convey("The message should be rejected", func() {
+				So(err, ShouldEqual, models.ErrMissingMessageGroupID)
+			})
+		})
+	})
+
+	Convey("Given a standard queue", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+		msg := models.NewMessage("foobar")
+
+		Convey("When send a message with group ID", func() {
+			err := queue.SetMessageGroup(&msg, "group", "")
+
+			Convey("The message should be rejected", func() {
+				So(err, ShouldEqual, models.ErrInvalidParameterForStandard)
+			})
+		})
+	})
+}
+
+func TestMD5OfMessageAttributes(t *testing.T) {
+	Convey("Given a message with attributes", t, func() {
+		msg := models.NewMessage("foobar")
+		msg.MessageAttributes = map[string]models.MessageAttribute{
+			"size":   {DataType: "Number", StringValue: "42"},
+			"bucket": {DataType: "String", StringValue: "photos"},
+		}
+
+		Convey("When digest its attributes", func() {
+			digest := msg.MD5OfMessageAttributes()
+
+			Convey("The attributes should be digested in order of their names", func() {
+				So(digest, ShouldEqual, "17d4c8ae40f46e8b2cbd5799b6818493")
+			})
+		})
+	})
+
+	Convey("Given a message without attributes", t, func() {
+		msg := models.NewMessage("foobar")
+
+		Convey("The digest should be empty", func() {
+			So(msg.MD5OfMessageAttributes(), ShouldEqual, "")
+		})
+	})
+}
+
+func TestReceiveMessages(t *testing.T) {
+	setup()
+
+	Convey("Given a queue with a message", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+		defer queue.DeleteMessages()
+		_, err := queue.SendMessages(models.NewMessage("foobar"))
+		So(err, ShouldBeNil)
+
+		Convey("When receive it", func() {
+			msgs, err := queue.ReceiveMessages(10, 100*time.Millisecond)
+			So(err, ShouldBeNil)
+			So(msgs, ShouldHaveLength, 1)
+
+			Convey("It should be invisible until its visibility timeout expires", func() {
+				invisible, _ := queue.ReceiveMessages(10, time.Minute)
+				So(invisible, ShouldBeEmpty)
+
+				time.Sleep(150 * time.Millisecond)
+				visible, _ := queue.ReceiveMessages(10, time.Minute)
+				So(visible, ShouldHaveLength, 1)
+				So(visible[0].ID, ShouldEqual, msgs[0].ID)
+				So(visible[0].ReceiveCount, ShouldEqual, 2)
+				So(visible[0].ReceiptHandle, ShouldNotEqual, msgs[0].ReceiptHandle)
+			})
+
+			Convey("Its receipt handle should be rejected once it is received again", func() {
+				time.Sleep(150 * time.Millisecond)
+				again, _ := queue.ReceiveMessages(10, time.Minute)
+				So(again, ShouldHaveLength, 1)
+
+				So(queue.ChangeMessageVisibility(msgs[0].ReceiptHandle, 0), ShouldEqual, models.ErrMessageNotInflight)
+				So(queue.DeleteMessage(msgs[0].ReceiptHandle), ShouldBeNil)
+				So(queue.ChangeMessageVisibility(again[0].ReceiptHandle, 0), ShouldBeNil)
+
+				kept, _ := queue.ReceiveMessages(10, time.Minute)
+				So(kept, ShouldHaveLength, 1)
+			})
+
+			Convey("It should be deleted with its receipt handle", func() {
+				So(queue.DeleteMessage(msgs[0].ReceiptHandle), ShouldBeNil)
+
+				time.Sleep(150 * time.Millisecond)
+				deleted, _ := queue.ReceiveMessages(10, time.Minute)
+				So(deleted, ShouldBeEmpty)
+			})
+
+			Convey("It should be visible at once when its visibility timeout is changed to zero", func() {
+				So(queue.ChangeMessageVisibility(msgs[0].ReceiptHandle, 0), ShouldBeNil)
+
+				visible, _ := queue.ReceiveMessages(10, time.Minute)
+				So(visible, ShouldHaveLength, 1)
+			})
+
+			Convey("It should stay invisible when its visibility timeout is extended", func() {
+				So(queue.ChangeMessageVisibility(msgs[0].ReceiptHandle, time.Minute), ShouldBeNil)
+
+				time.Sleep(150 * time.Millisecond)
+				invisible, _ := queue.ReceiveMessages(10, time.Minute)
+				So(invisible, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When change the visibility timeout with an invalid receipt handle", func() {
+			err := queue.ChangeMessageVisibility("foobar", 0)
+
+			Convey("It should be rejected", func() {
+				So(err, ShouldEqual, models.ErrReceiptHandleIsInvalid)
+			})
+		})
+	})
+
+	Convey("Given a queue with a dead-letter queue", t, func() {
+		deadLetterQueue := models.NewQueue("tester", "foobar-dlq")
+		defer deadLetterQueue.DeleteMessages()
+		queue := models.NewQueue("tester", "foobar")
+		queue.RedrivePolicy = models.RedrivePolicy{DeadLetterTargetArn: deadLetterQueue.ARN(), MaxReceiveCount: 2}.String()
+		defer queue.DeleteMessages()
+		_, err := queue.SendMessages(models.NewMessage("foobar"))
+		So(err, ShouldBeNil)
+
+		Convey("When its message is received maxReceiveCount times", func() {
+			for i := 0; i < 2; i++ {
+				msgs, _ := queue.ReceiveMessages(10, time.Minute)
+				So(msgs, ShouldHaveLength, 1)
+				So(queue.ChangeMessageVisibility(msgs[0].ReceiptHandle, 0), ShouldBeNil)
+			}
+
+			Convey("It should be moved to the dead-letter queue instead of being received again", func() {
+				msgs, _ := queue.ReceiveMessages(10, time.Minute)
+				So(msgs, ShouldBeEmpty)
+
+				moved, _ := deadLetterQueue.ReceiveMessages(10, time.Minute)
+				So(moved, ShouldHaveLength, 1)
+				So(moved[0].Body, ShouldEqual, "foobar")
+				So(moved[0].DeadLetterSourceArn, ShouldEqual, queue.ARN())
+				So(moved[0].ReceiveCount, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestQueueDepth(t *testing.T) {
+	setup()
+	defer func() {
+		os.Unsetenv("SQS_MAX_QUEUE_DEPTH")
+		os.Unsetenv("SQS_OVERFLOW_POLICY")
+		config.SetServerConfig()
+	}()
+
+	Convey("Given queues limited to two messages which reject messages", t, func() {
+		os.Setenv("SQS_MAX_QUEUE_DEPTH", "2")
+		os.Setenv("SQS_OVERFLOW_POLICY", "reject")
+		config.SetServerConfig()
+
+		queue := models.NewQueue("tester", "foobar")
+		defer queue.DeleteMessages()
+		_, err := queue.SendMessages(models.NewMessage("foo"), models.NewMessage("bar"))
+		So(err, ShouldBeNil)
+
+		Convey("When send a message to a full queue", func() {
+			_, err := queue.SendMessages(models.NewMessage("baz"))
+
+			Convey("It should be rejected and no messages should be dropped", func() {
+				So(err, ShouldEqual, models.ErrQueueFull)
+
+				attributes, _ := queue.Attributes()
+				So(attributes["ApproximateNumberOfMessages"], ShouldEqual, "2")
+				So(attributes["ApproximateNumberOfMessagesDropped"], ShouldEqual, "0")
+			})
+		})
+	})
+
+	Convey("Given queues limited to two messages which drop the oldest messages", t, func() {
+		os.Setenv("SQS_MAX_QUEUE_DEPTH", "2")
+		os.Setenv("SQS_OVERFLOW_POLICY", "drop-oldest")
+		config.SetServerConfig()
+
+		queue := models.NewQueue("tester", "foobar")
+		defer queue.DeleteMessages()
+		for _, body := range []string{"foo", "bar"} {
+			_, err := queue.SendMessages(models.NewMessage(body))
+			So(err, ShouldBeNil)
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		Convey("When send a message to a full queue", func() {
+			_, err := queue.SendMessages(models.NewMessage("baz"))
+
+			Convey("The oldest message should be dropped and counted", func() {
+				So(err, ShouldBeNil)
+
+				attributes, _ := queue.Attributes()
+				So(attributes["ApproximateNumberOfMessages"], ShouldEqual, "2")
+				So(attributes["ApproximateNumberOfMessagesDropped"], ShouldEqual, "1")
+
+				msgs, _ := queue.ReceiveMessages(10, time.Minute)
+				So(msgs, ShouldHaveLength, 2)
+				So(msgs[0].Body, ShouldEqual, "bar")
+				So(msgs[1].Body, ShouldEqual, "baz")
+			})
+		})
+	})
+}
+
+func TestMessageRetention(t *testing.T) {
+	setup()
+
+	Convey("Given a queue with more expired messages than are dropped at a time", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+		queue.MessageRetentionPeriod = 1
+		defer queue.DeleteMessages()
+
+		msgs := []models.Message{}
+		for i := 0; i < 150; i++ {
+			msgs = append(msgs, models.NewMessage("foobar"))
+		}
+		_, err := queue.SendMessages(msgs...)
+		So(err, ShouldBeNil)
+		time.Sleep(1100 * time.Millisecond)
+
+		Convey("When send a message", func() {
+			_, err := queue.SendMessages(models.NewMessage("foobar"))
+			So(err, ShouldBeNil)
+
+			Convey("At most 100 expired messages should be dropped", func() {
+				attributes, _ := queue.Attributes()
+				So(attributes["ApproximateNumberOfMessages"], ShouldEqual, "51")
+				So(attributes["ApproximateNumberOfMessagesDropped"], ShouldEqual, "100")
+			})
+
+			Convey("The rest should be dropped by the next receive", func() {
+				received, _ := queue.ReceiveMessages(10, time.Minute)
+				So(received, ShouldHaveLength, 1)
+
+				attributes, _ := queue.Attributes()
+				So(attributes["ApproximateNumberOfMessagesDropped"], ShouldEqual, "150")
+			})
+		})
+	})
+}
+
+func TestDelayedMessages(t *testing.T) {
+	setup()
+
+	Convey("Given a queue", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+		defer queue.DeleteMessages()
+
+		Convey("When send a delayed message", func() {
+			msg := models.NewMessage("foobar")
+			msg.DelaySeconds = 1
+			_, err := queue.SendMessages(msg)
+			So(err, ShouldBeNil)
+
+			Convey("It should only be received once it is due", func() {
+				attributes, _ := queue.Attributes()
+				So(attributes["ApproximateNumberOfMessagesDelayed"], ShouldEqual, "1")
+				So(attributes["ApproximateNumberOfMessages"], ShouldEqual, "0")
+
+				early, _ := queue.ReceiveMessages(10, time.Minute)
+				So(early, ShouldBeEmpty)
+
+				time.Sleep(1100 * time.Millisecond)
+				due, _ := queue.ReceiveMessages(10, time.Minute)
+				So(due, ShouldHaveLength, 1)
+				So(due[0].Body, ShouldEqual, "foobar")
+			})
+		})
+	})
+
+	Convey("Given a delay queue", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+		queue.DelaySeconds = 1
+		defer queue.DeleteMessages()
+
+		Convey("When send an event to it", func() {
+			_, err := queue.SendMessages(queue.NewEventMessage("{}", "photos", "cat.jpg", "s3:ObjectCreated:Put"))
+			So(err, ShouldBeNil)
+
+			Convey("It should be delayed by the delay of the queue", func() {
+				early, _ := queue.ReceiveMessages(10, time.Minute)
+				So(early, ShouldBeEmpty)
+
+				time.Sleep(1100 * time.Millisecond)
+				due, _ := queue.ReceiveMessages(10, time.Minute)
+				So(due, ShouldHaveLength, 1)
+			})
+		})
+	})
+
+	Convey("Given a FIFO delay queue", t, func() {
+		queue := models.NewQueue("tester", "foobar.fifo")
+		queue.DelaySeconds = 1
+		defer queue.DeleteMessages()
+
+		Convey("When send messages of a group to it", func() {
+			for _, body := range []string{"foo", "bar"} {
+				msg := models.NewMessage(body)
+				msg.DelaySeconds = queue.DelaySeconds
+				So(queue.SetMessageGroup(&msg, "group", msg.ID), ShouldBeNil)
+				_, err := queue.SendMessages(msg)
+				So(err, ShouldBeNil)
+			}
+
+			Convey("They should be received in order once they are due", func() {
+				early, _ := queue.ReceiveMessages(10, time.Minute)
+				So(early, ShouldBeEmpty)
+
+				time.Sleep(1100 * time.Millisecond)
+				due, _ := queue.ReceiveMessages(10, time.Minute)
+				So(due, ShouldHaveLength, 2)
+				So(due[0].Body, ShouldEqual, "foo")
+				So(due[1].Body, ShouldEqual, "bar")
+			})
+		})
+	})
+}
+
+func TestWaitMessages(t *testing.T) {
+	setup()
+
+	Convey("Given an empty queue", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+		defer queue.DeleteMessages()
+
+		Convey("When a message is sent while waiting for messages", func() {
+			start := time.Now()
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				queue.SendMessages(models.NewMessage("foobar"))
+			}()
+			msgs, err := queue.WaitMessages(context.Background(), 10, time.Minute, 5*time.Second)
+
+			Convey("The message should be received as soon as it is sent", func() {
+				So(err, ShouldBeNil)
+				So(msgs, ShouldHaveLength, 1)
+				So(time.Since(start), ShouldBeLessThan, 800*time.Millisecond)
+			})
+		})
+
+		Convey("When no message is sent while waiting for messages", func() {
+			start := time.Now()
+			msgs, err := queue.WaitMessages(context.Background(), 10, time.Minute, time.Second)
+
+			Convey("No messages should be received once the wait time is over", func() {
+				So(err, ShouldBeNil)
+				So(msgs, ShouldBeEmpty)
+				So(time.Since(start), ShouldBeGreaterThanOrEqualTo, time.Second)
+			})
+		})
+
+		Convey("When the request is cancelled while waiting for messages", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			start := time.Now()
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				cancel()
+			}()
+			msgs, err := queue.WaitMessages(ctx, 10, time.Minute, 5*time.Second)
+
+			Convey("No messages should be received at once", func() {
+				So(err, ShouldBeNil)
+				So(msgs, ShouldBeEmpty)
+				So(time.Since(start), ShouldBeLessThan, 500*time.Millisecond)
+			})
+		})
+	})
+}
+
+func TestLegacyMessages(t *testing.T) {
+	setup()
+
+	Convey("Given a queue with message bodies pushed by an older release", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+		defer queue.DeleteMessages()
+		So(models.GetCache().RPush(queue.QueueKey(), "foo").Err(), ShouldBeNil)
+		_, err := queue.SendMessages(models.NewMessage("bar"))
+		So(err, ShouldBeNil)
+
+		Convey("When receive messages", func() {
+			msgs, err := queue.ReceiveMessages(10, time.Minute)
+
+			Convey("The old messages should be received first and be deleted like others", func() {
+				So(err, ShouldBeNil)
+				So(msgs, ShouldHaveLength, 2)
+				So(msgs[0].Body, ShouldEqual, "foo")
+				So(msgs[1].Body, ShouldEqual, "bar")
+
+				So(queue.DeleteMessage(msgs[0].ReceiptHandle), ShouldBeNil)
+				So(queue.ChangeMessageVisibility(msgs[1].ReceiptHandle, 0), ShouldBeNil)
+				left, _ := queue.ReceiveMessages(10, time.Minute)
+				So(left, ShouldHaveLength, 1)
+				So(left[0].Body, ShouldEqual, "bar")
+			})
+		})
+	})
+}
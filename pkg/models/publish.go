@@ -0,0 +1,274 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/satori/go.uuid"
+
+	"github.com/inwinstack/kaoliang/pkg/config"
+)
+
+var (
+	ErrInvalidMessageStructure = errors.New("Invalid parameter: Message Structure - JSON message body failed to parse")
+	ErrMissingDefaultMessage   = errors.New("Invalid parameter: Message Structure - No default entry in JSON message body")
+)
+
+// Notification - a message published to a topic.
+type Notification struct {
+	MessageID         string
+	TopicArn          string
+	Subject           string
+	Message           string
+	MessageAttributes map[string]MessageAttribute
+	Timestamp         time.Time
+
+	// Version of the signatures of the notification, by the topic
+	SignatureVersion string
+
+	// Messages for each protocol when the message structure is json
+	ProtocolMessages map[string]string
+}
+
+// NewNotification - creates a notification with the message published to the
+// topic.
+func NewNotification(topic Resource, message string) Notification {
+	messageID, _ := uuid.NewV4()
+
+	return Notification{
+		MessageID:        messageID.String(),
+		TopicArn:         topic.ARN(),
+		Message:          message,
+		Timestamp:        time.Now().UTC(),
+		SignatureVersion: topic.EffectiveSignatureVersion(),
+	}
+}
+
+// NewEventNotification - creates the notification of the S3 event, with the
+// bucket, key, name of the event and size of the object as attributes so that
+// subscriptions can filter events by them.
+func (r Resource) NewEventNotification(body string, bucketName string, objectName string, eventName string, size int64) Notification {
+	n := NewNotification(r, body)
+	n.Subject = "Amazon S3 Notification"
+	n.MessageAttributes = map[string]MessageAttribute{
+		"bucket":    {DataType: "String", StringValue: bucketName},
+		"key":       {DataType: "String", StringValue: objectName},
+		"eventName": {DataType: "String", StringValue: eventName},
+		"size":      {DataType: "Number", StringValue: strconv.FormatInt(size, 10)},
+	}
+
+	return n
+}
+
+// SetMessageStructure - parses the message as a JSON object of messages for
+// each protocol when the structure is json, the default message is sent to
+// the protocols not in the object.
+func (n *Notification) SetMessageStructure(structure string) error {
+	if structure == "" {
+		return nil
+	}
+
+	if structure != "json" {
+		return ErrInvalidMessageStructure
+	}
+
+	messages := map[string]string{}
+	if err := json.Unmarshal([]byte(n.Message), &messages); err != nil {
+		return ErrInvalidMessageStructure
+	}
+
+	if _, ok := messages["default"]; !ok {
+		return ErrMissingDefaultMessage
+	}
+
+	n.ProtocolMessages = messages
+	return nil
+}
+
+// MessageFor - returns the message sent to endpoints of the protocol.
+func (n Notification) MessageFor(protocol string) string {
+	if n.ProtocolMessages == nil {
+		return n.Message
+	}
+
+	if message, ok := n.ProtocolMessages[protocol]; ok {
+		return message
+	}
+
+	return n.ProtocolMessages["default"]
+}
+
+// Size - returns the size of the notification counted against the maximum
+// message size, including the names, types and values of its attributes.
+func (n Notification) Size() int {
+	msg := Message{Body: n.Message, MessageAttributes: n.MessageAttributes}
+	return msg.Size()
+}
+
+type notificationAttribute struct {
+	Type  string
+	Value string
+}
+
+type notificationBody struct {
+	Type              string
+	MessageId         string
+	TopicArn          string
+	Subject           string `json:",omitempty"`
+	Message           string
+	Timestamp         string
+	UnsubscribeURL    string
+	SignatureVersion  string                           `json:",omitempty"`
+	Signature         string                           `json:",omitempty"`
+	SigningCertURL    string                           `json:",omitempty"`
+	MessageAttributes map[string]notificationAttribute `json:",omitempty"`
+}
+
+// Body - returns the JSON document delivered to the endpoint, signed when a
+// signing key is configured.
+func (n Notification) Body(endpoint Endpoint) string {
+	body := notificationBody{
+		Type:      "Notification",
+		MessageId: n.MessageID,
+		TopicArn:  n.TopicArn,
+		Subject:   n.Subject,
+		Message:   n.MessageFor(endpoint.Protocol),
+		Timestamp: n.Timestamp.Format("2006-01-02T15:04:05.000Z"),
+	}
+
+	serverConfig := config.GetServerConfig()
+	query := url.Values{}
+	query.Set("Action", "Unsubscribe")
+	query.Set("SubscriptionArn", n.TopicArn+":"+endpoint.Name)
+	query.Set("Token", endpoint.Token)
+	body.UnsubscribeURL = fmt.Sprintf("%s://%s/?%s", serverConfig.Scheme, serverConfig.Host, query.Encode())
+
+	if len(n.MessageAttributes) > 0 {
+		body.MessageAttributes = map[string]notificationAttribute{}
+		for name, attribute := range n.MessageAttributes {
+			value := attribute.StringValue
+			if attribute.IsBinary() {
+				value = base64.StdEncoding.EncodeToString(attribute.BinaryValue)
+			}
+			body.MessageAttributes[name] = notificationAttribute{Type: attribute.DataType, Value: value}
+		}
+	}
+
+	// Message attributes are not signed
+	signature, _ := signMessage(n.SignatureVersion,
+		"Message", body.Message,
+		"MessageId", body.MessageId,
+		"Subject", body.Subject,
+		"Timestamp", body.Timestamp,
+		"TopicArn", body.TopicArn,
+		"Type", body.Type,
+	)
+	if signature != "" {
+		body.SignatureVersion = n.SignatureVersion
+		body.Signature = signature
+		body.SigningCertURL = SigningCertURL()
+	}
+
+	data, _ := json.Marshal(body)
+	return string(data)
+}
+
+// Publish - delivers the notification to every confirmed endpoint subscribed
+// to the topic, the endpoints must be loaded with the topic. Endpoints failing
+// are logged and skipped like undeliverable http endpoints, since the others
+// already have the message. An error is returned only when the notification
+// is delivered to none of the endpoints.
+func (r Resource) Publish(n Notification) error {
+	var lastErr error
+	delivered := false
+	for _, endpoint := range r.ConfirmedEndpoints() {
+		if err := r.deliver(endpoint, n); err != nil {
+			log.Printf("An error occurred while delivering message %s to %s. %s\n", n.MessageID, endpoint.ARN(r), err)
+			lastErr = err
+			continue
+		}
+		delivered = true
+	}
+
+	if delivered {
+		return nil
+	}
+
+	return lastErr
+}
+
+// deliver - delivers the notification to the endpoint if its filter policy
+// matches the notification. Endpoints receive the notification in the JSON
+// envelope, or only the message when they enable raw message delivery.
+func (r Resource) deliver(endpoint Endpoint, n Notification) error {
+	if !endpoint.MatchFilterPolicy(n) {
+		return nil
+	}
+
+	if endpoint.Protocol == "sqs" {
+		return r.sendToQueue(endpoint, n)
+	}
+
+	headers := map[string]string{
+		"x-amz-sns-message-type":     "Notification",
+		"x-amz-sns-message-id":       n.MessageID,
+		"x-amz-sns-topic-arn":        n.TopicArn,
+		"x-amz-sns-subscription-arn": endpoint.ARN(r),
+	}
+	if endpoint.RawMessageDelivery {
+		headers["x-amz-sns-rawdelivery"] = "true"
+		return sendEvent(endpoint, n.MessageID, n.MessageFor(endpoint.Protocol), headers)
+	}
+
+	return sendEvent(endpoint, n.MessageID, n.Body(endpoint), headers)
+}
+
+// sendToQueue - sends the notification to the queue of the sqs endpoint.
+// Like undeliverable http endpoints, queues that no longer exist or no longer
+// permit the topic to send are skipped.
+func (r Resource) sendToQueue(endpoint Endpoint, n Notification) error {
+	target, err := ParseARN(endpoint.URI)
+	if err != nil {
+		return nil
+	}
+
+	queue := Resource{}
+	if db.Where(Resource{Service: SQS, AccountID: target.AccountID, Name: target.Name}).First(&queue).RecordNotFound() {
+		return nil
+	}
+
+	if !queue.AllowsSendFrom(r) {
+		return nil
+	}
+
+	msg := NewMessage(n.Body(endpoint))
+	if endpoint.RawMessageDelivery {
+		// Message attributes of raw messages become attributes of the message
+		msg = NewMessage(n.MessageFor(endpoint.Protocol))
+		msg.MessageAttributes = n.MessageAttributes
+	}
+	msg.DelaySeconds = queue.DelaySeconds
+	msg.SenderID = r.AccountID
+	_, err = queue.SendMessages(msg)
+	return err
+}
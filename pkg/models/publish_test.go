@@ -0,0 +1,62 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetMessageStructure(t *testing.T) {
+	setup()
+
+	Convey("Given a notification with messages for each protocol", t, func() {
+		topic := models.Resource{Service: models.SNS, AccountID: "tester", Name: "foobar"}
+		n := models.NewNotification(topic, `{"default": "foo", "http": "bar"}`)
+
+		Convey("When its message structure is json", func() {
+			err := n.SetMessageStructure("json")
+
+			Convey("Each protocol should get its own message or the default one", func() {
+				So(err, ShouldBeNil)
+				So(n.MessageFor("http"), ShouldEqual, "bar")
+				So(n.MessageFor("https"), ShouldEqual, "foo")
+			})
+		})
+	})
+
+	Convey("Given a notification without default message", t, func() {
+		topic := models.Resource{Service: models.SNS, AccountID: "tester", Name: "foobar"}
+		n := models.NewNotification(topic, `{"http": "bar"}`)
+
+		Convey("When its message structure is json", func() {
+			err := n.SetMessageStructure("json")
+
+			Convey("The notification should be rejected", func() {
+				So(err, ShouldEqual, models.ErrMissingDefaultMessage)
+			})
+		})
+	})
+}
+
+func TestNotificationBody(t *testing.T) {
+	setup()
+
+	Convey("Given a notification of an S3 event", t, func() {
+		topic := models.Resource{Service: models.SNS, AccountID: "tester", Name: "foobar"}
+		n := topic.NewEventNotification(`{"eventName": "s3:ObjectCreated:Put"}`, "photos", "cat.jpg", "s3:ObjectCreated:Put", 1024)
+		endpoint := models.Endpoint{Protocol: "http", Name: "foo", Token: "bar"}
+
+		Convey("The event should be delivered in the envelope", func() {
+			body := map[string]interface{}{}
+			So(json.Unmarshal([]byte(n.Body(endpoint)), &body), ShouldBeNil)
+			So(body["Type"], ShouldEqual, "Notification")
+			So(body["Message"], ShouldEqual, `{"eventName": "s3:ObjectCreated:Put"}`)
+			So(body["Subject"], ShouldEqual, "Amazon S3 Notification")
+			So(body["UnsubscribeURL"], ShouldEqual, "http://cloud.inwinstack.com/?Action=Unsubscribe&SubscriptionArn=arn%3Aaws%3Asns%3Aus-east-1%3Atester%3Afoobar%3Afoo&Token=bar")
+			So(body["MessageAttributes"], ShouldNotBeEmpty)
+		})
+	})
+}
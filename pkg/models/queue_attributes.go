@@ -0,0 +1,340 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const (
+	DefaultVisibilityTimeout      = 30
+	DefaultMessageRetentionPeriod = 345600
+	DefaultMaximumMessageSize     = 262144
+)
+
+var (
+	ErrInvalidAttributeName  = errors.New("Unknown Attribute.")
+	ErrInvalidAttributeValue = errors.New("Invalid value for the parameter.")
+)
+
+// queueAttributeRanges - valid ranges of the attributes that can be set.
+var queueAttributeRanges = map[string][2]int{
+	"VisibilityTimeout":             {0, 43200},
+	"MessageRetentionPeriod":        {60, 1209600},
+	"DelaySeconds":                  {0, 900},
+	"MaximumMessageSize":            {1024, 262144},
+	"ReceiveMessageWaitTimeSeconds": {0, 20},
+}
+
+// QueueAttributeNames - names of all attributes returned for a queue.
+var QueueAttributeNames = []string{
+	"ApproximateNumberOfMessages",
+	"ApproximateNumberOfMessagesDelayed",
+	"ApproximateNumberOfMessagesDropped",
+	"ApproximateNumberOfMessagesNotVisible",
+	"ContentBasedDeduplication",
+	"CreatedTimestamp",
+	"DelaySeconds",
+	"FifoQueue",
+	"LastModifiedTimestamp",
+	"MaximumMessageSize",
+	"MessageRetentionPeriod",
+	"Policy",
+	"QueueArn",
+	"ReceiveMessageWaitTimeSeconds",
+	"RedrivePolicy",
+	"VisibilityTimeout",
+}
+
+// RedrivePolicy - the dead-letter queue messages are moved to after they are
+// received maxReceiveCount times.
+type RedrivePolicy struct {
+	DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+	MaxReceiveCount     int    `json:"maxReceiveCount"`
+}
+
+// ParseRedrivePolicy - parses the RedrivePolicy attribute, maxReceiveCount may
+// be given either as number or as string.
+func ParseRedrivePolicy(s string) (*RedrivePolicy, error) {
+	var policy struct {
+		DeadLetterTargetArn string      `json:"deadLetterTargetArn"`
+		MaxReceiveCount     json.Number `json:"maxReceiveCount"`
+	}
+	if err := json.Unmarshal([]byte(s), &policy); err != nil {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	target, err := ParseARN(policy.DeadLetterTargetArn)
+	if err != nil || target.Service != SQS {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	maxReceiveCount, err := strconv.Atoi(policy.MaxReceiveCount.String())
+	if err != nil || maxReceiveCount < 1 || maxReceiveCount > 1000 {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	return &RedrivePolicy{
+		DeadLetterTargetArn: policy.DeadLetterTargetArn,
+		MaxReceiveCount:     maxReceiveCount,
+	}, nil
+}
+
+func (p RedrivePolicy) String() string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// NewQueue - creates a queue resource with default attributes, queues named
+// with the .fifo suffix are FIFO queues.
+func NewQueue(accountID string, name string) Resource {
+	return Resource{
+		Service:                SQS,
+		AccountID:              accountID,
+		Name:                   name,
+		VisibilityTimeout:      DefaultVisibilityTimeout,
+		MessageRetentionPeriod: DefaultMessageRetentionPeriod,
+		MaximumMessageSize:     DefaultMaximumMessageSize,
+		FifoQueue:              strings.HasSuffix(name, ".fifo"),
+	}
+}
+
+// SetAttribute - validates and sets the queue attribute, read-only and
+// unknown attributes are rejected with ErrInvalidAttributeName. FifoQueue is
+// decided by the queue name and can only be set to the same value.
+func (r *Resource) SetAttribute(name string, value string) error {
+	switch name {
+	case "RedrivePolicy":
+		return r.setRedrivePolicy(value)
+	case "Policy":
+		return r.setPolicy(value)
+	case "FifoQueue":
+		fifoQueue, err := parseBoolAttribute(value)
+		if err != nil || fifoQueue != r.FifoQueue {
+			return ErrInvalidAttributeValue
+		}
+		return nil
+	case "ContentBasedDeduplication":
+		if !r.FifoQueue {
+			return ErrInvalidAttributeName
+		}
+		contentBasedDeduplication, err := parseBoolAttribute(value)
+		if err != nil {
+			return ErrInvalidAttributeValue
+		}
+		r.ContentBasedDeduplication = contentBasedDeduplication
+		return nil
+	}
+
+	valueRange, ok := queueAttributeRanges[name]
+	if !ok {
+		return ErrInvalidAttributeName
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < valueRange[0] || n > valueRange[1] {
+		return ErrInvalidAttributeValue
+	}
+
+	switch name {
+	case "VisibilityTimeout":
+		r.VisibilityTimeout = n
+	case "MessageRetentionPeriod":
+		r.MessageRetentionPeriod = n
+	case "DelaySeconds":
+		r.DelaySeconds = n
+	case "MaximumMessageSize":
+		r.MaximumMessageSize = n
+	case "ReceiveMessageWaitTimeSeconds":
+		r.ReceiveMessageWaitTimeSeconds = n
+	}
+
+	return nil
+}
+
+func parseBoolAttribute(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, ErrInvalidAttributeValue
+	}
+}
+
+// setRedrivePolicy - sets the RedrivePolicy attribute, the dead-letter queue
+// must be another existing queue of the same account and type. An empty value
+// removes the policy.
+func (r *Resource) setRedrivePolicy(value string) error {
+	if value == "" {
+		r.RedrivePolicy = ""
+		return nil
+	}
+
+	policy, err := ParseRedrivePolicy(value)
+	if err != nil {
+		return err
+	}
+
+	target, _ := ParseARN(policy.DeadLetterTargetArn)
+	if target.AccountID != r.AccountID || target.Name == r.Name {
+		return ErrInvalidAttributeValue
+	}
+
+	queue := Resource{}
+	if db.Where(Resource{Service: SQS, AccountID: target.AccountID, Name: target.Name}).First(&queue).RecordNotFound() {
+		return ErrInvalidAttributeValue
+	}
+
+	if queue.FifoQueue != r.FifoQueue {
+		return ErrInvalidAttributeValue
+	}
+
+	r.RedrivePolicy = policy.String()
+	return nil
+}
+
+// setPolicy - sets the Policy attribute, which is stored as it is given. An
+// empty value removes the policy.
+func (r *Resource) setPolicy(value string) error {
+	if value == "" {
+		r.Policy = ""
+		return nil
+	}
+
+	if _, err := ParseQueuePolicy(value); err != nil {
+		return err
+	}
+
+	r.Policy = value
+	return nil
+}
+
+// DeadLetterSourceQueues - returns the queues that use this queue as their
+// dead-letter queue.
+func (r Resource) DeadLetterSourceQueues() []Resource {
+	queues := []Resource{}
+	db.Where(Resource{Service: SQS, AccountID: r.AccountID}).Where("redrive_policy <> ''").Find(&queues)
+
+	sources := []Resource{}
+	for _, queue := range queues {
+		policy, err := ParseRedrivePolicy(queue.RedrivePolicy)
+		if err != nil {
+			continue
+		}
+
+		target, _ := ParseARN(policy.DeadLetterTargetArn)
+		if target.AccountID == r.AccountID && target.Name == r.Name {
+			sources = append(sources, queue)
+		}
+	}
+
+	return sources
+}
+
+func (r Resource) settableAttributes() map[string]string {
+	attributes := map[string]string{
+		"DelaySeconds":                  strconv.Itoa(r.DelaySeconds),
+		"MaximumMessageSize":            strconv.Itoa(r.MaximumMessageSize),
+		"MessageRetentionPeriod":        strconv.Itoa(r.MessageRetentionPeriod),
+		"ReceiveMessageWaitTimeSeconds": strconv.Itoa(r.ReceiveMessageWaitTimeSeconds),
+		"VisibilityTimeout":             strconv.Itoa(r.VisibilityTimeout),
+	}
+
+	if r.RedrivePolicy != "" {
+		attributes["RedrivePolicy"] = r.RedrivePolicy
+	}
+
+	if r.Policy != "" {
+		attributes["Policy"] = r.Policy
+	}
+
+	if r.FifoQueue {
+		attributes["FifoQueue"] = "true"
+		attributes["ContentBasedDeduplication"] = strconv.FormatBool(r.ContentBasedDeduplication)
+	}
+
+	return attributes
+}
+
+// IsQueueAttributeName - reports whether name is an attribute of queues.
+func IsQueueAttributeName(name string) bool {
+	for _, attributeName := range QueueAttributeNames {
+		if attributeName == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchAttributes - reports whether both queues have the same values for the
+// named attributes.
+func (r Resource) MatchAttributes(queue Resource, names []string) bool {
+	attributes := r.settableAttributes()
+	queueAttributes := queue.settableAttributes()
+	for _, name := range names {
+		if attributes[name] != queueAttributes[name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Attributes - returns all attributes of the queue, including the computed
+// ones.
+func (r Resource) Attributes() (map[string]string, error) {
+	now := strconv.FormatInt(unixMilli(time.Now()), 10)
+
+	client := GetCache()
+	var stored, notVisible, delayed *redis.IntCmd
+	var dropped *redis.StringCmd
+	_, err := client.Pipelined(func(pipe redis.Pipeliner) error {
+		stored = pipe.HLen(r.messagesKey())
+		notVisible = pipe.ZCount(r.inflightKey(), "("+now, "+inf")
+		delayed = pipe.ZCount(r.delayedKey(), "("+now, "+inf")
+		dropped = pipe.Get(r.droppedKey())
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	// The list may still hold entries of removed messages, so messages are
+	// counted by their records
+	approximateNumberOfMessages := stored.Val() - notVisible.Val() - delayed.Val()
+
+	// Messages dropped because they are expired or the queue is full
+	droppedCount, _ := dropped.Int64()
+
+	attributes := r.settableAttributes()
+	attributes["ApproximateNumberOfMessages"] = strconv.FormatInt(approximateNumberOfMessages, 10)
+	attributes["ApproximateNumberOfMessagesDelayed"] = strconv.FormatInt(delayed.Val(), 10)
+	attributes["ApproximateNumberOfMessagesDropped"] = strconv.FormatInt(droppedCount, 10)
+	attributes["ApproximateNumberOfMessagesNotVisible"] = strconv.FormatInt(notVisible.Val(), 10)
+	attributes["CreatedTimestamp"] = strconv.FormatInt(r.CreatedAt.Unix(), 10)
+	attributes["LastModifiedTimestamp"] = strconv.FormatInt(r.UpdatedAt.Unix(), 10)
+	attributes["QueueArn"] = r.ARN()
+
+	return attributes, nil
+}
@@ -0,0 +1,104 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetAttribute(t *testing.T) {
+	Convey("Given a queue with default attributes", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+
+		Convey("When set an attribute in its range", func() {
+			err := queue.SetAttribute("VisibilityTimeout", "0")
+
+			Convey("The attribute should be changed", func() {
+				So(err, ShouldBeNil)
+				So(queue.VisibilityTimeout, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When set an attribute out of its range", func() {
+			err := queue.SetAttribute("MessageRetentionPeriod", "59")
+
+			Convey("The attribute should be rejected", func() {
+				So(err, ShouldEqual, models.ErrInvalidAttributeValue)
+				So(queue.MessageRetentionPeriod, ShouldEqual, models.DefaultMessageRetentionPeriod)
+			})
+		})
+
+		Convey("When set a read-only attribute", func() {
+			err := queue.SetAttribute("QueueArn", "arn:aws:sqs:us-east-1:tester:foobar")
+
+			Convey("The attribute should be rejected", func() {
+				So(err, ShouldEqual, models.ErrInvalidAttributeName)
+			})
+		})
+	})
+}
+
+func TestSetFifoAttribute(t *testing.T) {
+	Convey("Given a FIFO queue", t, func() {
+		queue := models.NewQueue("tester", "foobar.fifo")
+
+		Convey("When enable content-based deduplication", func() {
+			err := queue.SetAttribute("ContentBasedDeduplication", "true")
+
+			Convey("The attribute should be changed", func() {
+				So(err, ShouldBeNil)
+				So(queue.ContentBasedDeduplication, ShouldBeTrue)
+			})
+		})
+
+		Convey("When set it to a standard queue", func() {
+			err := queue.SetAttribute("FifoQueue", "false")
+
+			Convey("The attribute should be rejected", func() {
+				So(err, ShouldEqual, models.ErrInvalidAttributeValue)
+			})
+		})
+	})
+
+	Convey("Given a standard queue", t, func() {
+		queue := models.NewQueue("tester", "foobar")
+
+		Convey("When enable content-based deduplication", func() {
+			err := queue.SetAttribute("ContentBasedDeduplication", "true")
+
+			Convey("The attribute should be rejected", func() {
+				So(err, ShouldEqual, models.ErrInvalidAttributeName)
+			})
+		})
+	})
+}
+
+func TestParseRedrivePolicy(t *testing.T) {
+	Convey("Given a redrive policy with maxReceiveCount in string", t, func() {
+		s := `{"deadLetterTargetArn":"arn:aws:sqs:us-east-1:tester:dead","maxReceiveCount":"5"}`
+
+		Convey("When parse it", func() {
+			policy, err := models.ParseRedrivePolicy(s)
+
+			Convey("The dead-letter queue and maxReceiveCount should be parsed", func() {
+				So(err, ShouldBeNil)
+				So(policy.DeadLetterTargetArn, ShouldEqual, "arn:aws:sqs:us-east-1:tester:dead")
+				So(policy.MaxReceiveCount, ShouldEqual, 5)
+			})
+		})
+	})
+
+	Convey("Given a redrive policy targets a topic", t, func() {
+		s := `{"deadLetterTargetArn":"arn:aws:sns:us-east-1:tester:dead","maxReceiveCount":5}`
+
+		Convey("When parse it", func() {
+			_, err := models.ParseRedrivePolicy(s)
+
+			Convey("The policy should be rejected", func() {
+				So(err, ShouldEqual, models.ErrInvalidAttributeValue)
+			})
+		})
+	})
+}
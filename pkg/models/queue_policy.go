@@ -0,0 +1,265 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// stringList - a policy element given either as a string or as a list of
+// strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*l = stringList(list)
+	return nil
+}
+
+// policyPrincipal - the principal of a statement, either "*" for everyone or
+// the AWS accounts and services.
+type policyPrincipal struct {
+	Everyone bool
+	AWS      stringList
+	Service  stringList
+}
+
+func (p *policyPrincipal) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		if s != "*" {
+			return ErrInvalidAttributeValue
+		}
+		p.Everyone = true
+		return nil
+	}
+
+	var principal struct {
+		AWS     stringList
+		Service stringList
+	}
+	if err := json.Unmarshal(data, &principal); err != nil {
+		return err
+	}
+
+	p.AWS = principal.AWS
+	p.Service = principal.Service
+	return nil
+}
+
+type policyStatement struct {
+	Sid       string
+	Effect    string
+	Principal *policyPrincipal
+	Action    stringList
+	Resource  stringList
+	Condition map[string]map[string]stringList
+}
+
+// QueuePolicy - the access policy of a queue. Only statements about sending
+// messages are evaluated, they allow accounts other than the owner to send
+// messages to the queue, such as by subscribing it to their topics.
+type QueuePolicy struct {
+	Version   string
+	ID        string `json:"Id"`
+	Statement []policyStatement
+}
+
+// ParseQueuePolicy - parses the Policy attribute, every statement must have
+// an effect, a principal and actions.
+func ParseQueuePolicy(s string) (*QueuePolicy, error) {
+	policy := QueuePolicy{}
+	if err := json.Unmarshal([]byte(s), &policy); err != nil {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	if len(policy.Statement) == 0 {
+		return nil, ErrInvalidAttributeValue
+	}
+
+	for _, statement := range policy.Statement {
+		if statement.Effect != "Allow" && statement.Effect != "Deny" {
+			return nil, ErrInvalidAttributeValue
+		}
+		if statement.Principal == nil || len(statement.Action) == 0 {
+			return nil, ErrInvalidAttributeValue
+		}
+	}
+
+	return &policy, nil
+}
+
+// AllowsSend - reports whether the account can send messages to the queue
+// on behalf of the source, explicit denials override allowances.
+func (p QueuePolicy) AllowsSend(accountID string, queueARN string, sourceARN string) bool {
+	allowed := false
+	for _, statement := range p.Statement {
+		if !statement.matchSend(accountID, queueARN, sourceARN) {
+			continue
+		}
+
+		if statement.Effect == "Deny" {
+			return false
+		}
+		allowed = true
+	}
+
+	return allowed
+}
+
+func (s policyStatement) matchSend(accountID string, queueARN string, sourceARN string) bool {
+	if !s.Principal.match(accountID) {
+		return false
+	}
+
+	if !matchAny(s.Action, "sqs:SendMessage", true) {
+		return false
+	}
+
+	if len(s.Resource) > 0 && !matchAny(s.Resource, queueARN, false) {
+		return false
+	}
+
+	context := map[string]string{
+		"aws:sourcearn":     sourceARN,
+		"aws:sourceaccount": accountID,
+	}
+	for operator, conditions := range s.Condition {
+		for key, values := range conditions {
+			value, ok := context[strings.ToLower(key)]
+			if !ok || !matchCondition(operator, values, value) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// match - reports whether the principal is the account. Topics of the
+// account are delivered by the SNS service, so the service principal matches
+// too.
+func (p policyPrincipal) match(accountID string) bool {
+	if p.Everyone {
+		return true
+	}
+
+	for _, principal := range p.AWS {
+		if principal == "*" || principal == accountID || principal == "arn:aws:iam::"+accountID+":root" {
+			return true
+		}
+	}
+
+	for _, service := range p.Service {
+		if service == "sns.amazonaws.com" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchCondition - reports whether the value satisfies the condition, only
+// the string and ARN operators are supported and others never match.
+func matchCondition(operator string, values stringList, value string) bool {
+	switch operator {
+	case "StringEquals", "ArnEquals":
+		return containsString(values, value)
+	case "StringNotEquals", "ArnNotEquals":
+		return !containsString(values, value)
+	case "StringLike", "ArnLike":
+		return matchAny(values, value, false)
+	case "StringNotLike", "ArnNotLike":
+		return !matchAny(values, value, false)
+	}
+
+	return false
+}
+
+// matchAny - reports whether the value matches any of the patterns, which
+// may contain * and ? wildcards.
+func matchAny(patterns stringList, value string, ignoreCase bool) bool {
+	for _, pattern := range patterns {
+		if ignoreCase {
+			pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+		}
+		if matchWildcard(pattern, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values stringList, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchWildcard(pattern string, value string) bool {
+	if pattern == "" {
+		return value == ""
+	}
+
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(value); i++ {
+			if matchWildcard(pattern[1:], value[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return value != "" && matchWildcard(pattern[1:], value[1:])
+	}
+
+	return value != "" && pattern[0] == value[0] && matchWildcard(pattern[1:], value[1:])
+}
+
+// AllowsSendFrom - reports whether the topic can deliver notifications to
+// the queue, which is only allowed for topics of other accounts by the queue
+// policy.
+func (r Resource) AllowsSendFrom(topic Resource) bool {
+	if topic.AccountID == r.AccountID {
+		return true
+	}
+
+	if r.Policy == "" {
+		return false
+	}
+
+	policy, err := ParseQueuePolicy(r.Policy)
+	if err != nil {
+		return false
+	}
+
+	return policy.AllowsSend(topic.AccountID, r.ARN(), topic.ARN())
+}
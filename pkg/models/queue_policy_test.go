@@ -0,0 +1,60 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAllowsSend(t *testing.T) {
+	Convey("Given a queue policy allowing a topic of another account", t, func() {
+		policy, err := models.ParseQueuePolicy(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Effect": "Allow",
+				"Principal": {"AWS": "publisher"},
+				"Action": "sqs:SendMessage",
+				"Resource": "arn:aws:sqs:us-east-1:tester:foobar",
+				"Condition": {"ArnLike": {"aws:SourceArn": "arn:aws:sns:*:publisher:events-*"}}
+			}]
+		}`)
+		So(err, ShouldBeNil)
+
+		Convey("The topic should be allowed to send messages", func() {
+			So(policy.AllowsSend("publisher", "arn:aws:sqs:us-east-1:tester:foobar", "arn:aws:sns:us-east-1:publisher:events-s3"), ShouldBeTrue)
+		})
+
+		Convey("Other topics of the account should not be allowed", func() {
+			So(policy.AllowsSend("publisher", "arn:aws:sqs:us-east-1:tester:foobar", "arn:aws:sns:us-east-1:publisher:other"), ShouldBeFalse)
+		})
+
+		Convey("Other accounts should not be allowed", func() {
+			So(policy.AllowsSend("stranger", "arn:aws:sqs:us-east-1:tester:foobar", "arn:aws:sns:us-east-1:stranger:events-s3"), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a queue policy denying an account allowed by another statement", t, func() {
+		policy, err := models.ParseQueuePolicy(`{
+			"Statement": [
+				{"Effect": "Allow", "Principal": "*", "Action": "sqs:*"},
+				{"Effect": "Deny", "Principal": {"AWS": ["arn:aws:iam::stranger:root"]}, "Action": "*"}
+			]
+		}`)
+		So(err, ShouldBeNil)
+
+		Convey("The denial should override the allowance", func() {
+			So(policy.AllowsSend("publisher", "arn:aws:sqs:us-east-1:tester:foobar", "arn:aws:sns:us-east-1:publisher:events"), ShouldBeTrue)
+			So(policy.AllowsSend("stranger", "arn:aws:sqs:us-east-1:tester:foobar", "arn:aws:sns:us-east-1:stranger:events"), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a queue policy without effect", t, func() {
+		_, err := models.ParseQueuePolicy(`{"Statement": [{"Principal": "*", "Action": "sqs:SendMessage"}]}`)
+
+		Convey("The policy should be rejected", func() {
+			So(err, ShouldEqual, models.ErrInvalidAttributeValue)
+		})
+	})
+}
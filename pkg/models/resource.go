@@ -59,6 +59,23 @@ type Resource struct {
 	AccountID string
 	Type      string
 	Name      string
+
+	// Attributes of SQS queues
+	VisibilityTimeout             int
+	MessageRetentionPeriod        int
+	DelaySeconds                  int
+	MaximumMessageSize            int
+	ReceiveMessageWaitTimeSeconds int
+	RedrivePolicy                 string `gorm:"type:varchar(1024)"`
+	Policy                        string `gorm:"type:text"`
+	FifoQueue                     bool
+	ContentBasedDeduplication     bool
+
+	// Attributes of SNS topics, which share Policy with queues
+	DisplayName      string
+	DeliveryPolicy   string `gorm:"type:text"`
+	SignatureVersion string
+
 	Endpoints []Endpoint
 	Queues    []Queue
 	Topics    []Topic
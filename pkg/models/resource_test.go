@@ -11,6 +11,7 @@ import (
 
 func setup() {
 	config.SetServerConfig()
+	models.SetCache()
 }
 
 func TestARN(t *testing.T) {
@@ -0,0 +1,125 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/inwinstack/kaoliang/pkg/config"
+)
+
+// SigningCertPath - path the sns service serves the signing certificate at.
+const SigningCertPath = "/SimpleNotificationService.pem"
+
+var signingKey *rsa.PrivateKey
+
+// SetSigningKey - loads the private key messages to endpoints are signed
+// with, messages are not signed when no key is configured.
+func SetSigningKey() {
+	signingKey = nil
+	keyFile := config.GetServerConfig().SigningKeyFile
+	if keyFile == "" {
+		return
+	}
+
+	key, err := loadSigningKey(keyFile)
+	if err != nil {
+		panic(err)
+	}
+	signingKey = key
+}
+
+// loadSigningKey - reads the RSA private key in PKCS #1 or PKCS #8 PEM.
+func loadSigningKey(keyFile string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no private key found in " + keyFile)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the private key in " + keyFile + " is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+// SigningCertURL - returns the URL of the certificate receivers verify the
+// signatures of messages with.
+func SigningCertURL() string {
+	serverConfig := config.GetServerConfig()
+	return fmt.Sprintf("%s://%s%s", serverConfig.Scheme, serverConfig.Host, SigningCertPath)
+}
+
+// signMessage - signs the fields of the message, given as pairs of names and
+// values in the order of their names. Fields with empty values, like missing
+// subjects, are not signed. Version 1 signs with SHA1 and version 2 with
+// SHA256, and the signature is empty when no signing key is configured.
+func signMessage(version string, fields ...string) (string, error) {
+	if signingKey == nil {
+		return "", nil
+	}
+
+	var b bytes.Buffer
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] == "" {
+			continue
+		}
+		b.WriteString(fields[i] + "\n" + fields[i+1] + "\n")
+	}
+
+	var hash crypto.Hash
+	var digest []byte
+	switch version {
+	case "2":
+		sum := sha256.Sum256([]byte(b.String()))
+		hash, digest = crypto.SHA256, sum[:]
+	default:
+		sum := sha1.Sum([]byte(b.String()))
+		hash, digest = crypto.SHA1, sum[:]
+	}
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signingKey, hash, digest)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
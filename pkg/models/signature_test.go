@@ -0,0 +1,62 @@
+package models_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/config"
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSignedNotification(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keyFile, _ := ioutil.TempFile("", "signing-key")
+	defer os.Remove(keyFile.Name())
+	pem.Encode(keyFile, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	keyFile.Close()
+
+	os.Setenv("SNS_SIGNING_KEY_FILE", keyFile.Name())
+	defer os.Unsetenv("SNS_SIGNING_KEY_FILE")
+	config.SetServerConfig()
+	models.SetSigningKey()
+	defer func() {
+		setup()
+		models.SetSigningKey()
+	}()
+
+	Convey("Given a notification from a topic with signature version 2", t, func() {
+		topic := models.Resource{Service: models.SNS, AccountID: "tester", Name: "foobar", SignatureVersion: "2"}
+		n := models.NewNotification(topic, "Hello")
+		n.Subject = "Greetings"
+
+		body := map[string]string{}
+		json.Unmarshal([]byte(n.Body(models.Endpoint{Protocol: "https"})), &body)
+
+		Convey("The body should point to the signing certificate", func() {
+			So(body["SignatureVersion"], ShouldEqual, "2")
+			So(body["SigningCertURL"], ShouldEqual, "http://cloud.inwinstack.com/SimpleNotificationService.pem")
+		})
+
+		Convey("The signature should be verified with the public key", func() {
+			stringToSign := ""
+			for _, name := range []string{"Message", "MessageId", "Subject", "Timestamp", "TopicArn", "Type"} {
+				stringToSign += name + "\n" + body[name] + "\n"
+			}
+			digest := sha256.Sum256([]byte(stringToSign))
+			signature, _ := base64.StdEncoding.DecodeString(body["Signature"])
+
+			So(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,268 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package models
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+var displayNameRegexp = regexp.MustCompile(`^[ -~]{0,100}$`)
+
+// TopicAttributes - returns the attributes of the topic, including values
+// computed from its subscriptions. The endpoints must be loaded with the topic,
+// and attributes like Policy are only returned when they are set.
+func (r Resource) TopicAttributes() map[string]string {
+	confirmed, pending := 0, 0
+	for _, endpoint := range r.Endpoints {
+		if endpoint.PendingConfirmation {
+			pending++
+		} else {
+			confirmed++
+		}
+	}
+
+	attributes := map[string]string{
+		"TopicArn":                r.ARN(),
+		"Owner":                   r.AccountID,
+		"DisplayName":             r.DisplayName,
+		"SubscriptionsConfirmed":  strconv.Itoa(confirmed),
+		"SubscriptionsPending":    strconv.Itoa(pending),
+		"SubscriptionsDeleted":    "0",
+		"EffectiveDeliveryPolicy": r.effectiveDeliveryPolicy(),
+		"SignatureVersion":        r.EffectiveSignatureVersion(),
+	}
+	if r.Policy != "" {
+		attributes["Policy"] = r.Policy
+	}
+	if r.DeliveryPolicy != "" {
+		attributes["DeliveryPolicy"] = r.DeliveryPolicy
+	}
+
+	return attributes
+}
+
+// effectiveDeliveryPolicy - returns the delivery policy of the topic with the
+// default retry policy when the topic has none.
+func (r Resource) effectiveDeliveryPolicy() string {
+	policy := &TopicDeliveryPolicy{}
+	if r.DeliveryPolicy != "" {
+		if parsed, err := ParseTopicDeliveryPolicy(r.DeliveryPolicy); err == nil {
+			policy = parsed
+		}
+	}
+
+	if policy.HTTP == nil {
+		policy.HTTP = &HTTPDeliveryPolicy{}
+	}
+	if policy.HTTP.DefaultHealthyRetryPolicy == nil {
+		retryPolicy := DefaultRetryPolicy
+		policy.HTTP.DefaultHealthyRetryPolicy = &retryPolicy
+	}
+
+	data, _ := json.Marshal(policy)
+	return string(data)
+}
+
+// EffectiveSignatureVersion - returns the version of the signatures of
+// messages from the topic, which is SHA1 based 1 unless it is set to 2.
+func (r Resource) EffectiveSignatureVersion() string {
+	if r.SignatureVersion == "" {
+		return "1"
+	}
+
+	return r.SignatureVersion
+}
+
+// SetTopicAttribute - validates and sets the topic attribute, unknown
+// attributes are rejected with ErrInvalidAttributeName. An empty value
+// removes the attribute.
+func (r *Resource) SetTopicAttribute(name string, value string) error {
+	switch name {
+	case "DisplayName":
+		if !displayNameRegexp.MatchString(value) {
+			return ErrInvalidAttributeValue
+		}
+		r.DisplayName = value
+		return nil
+	case "Policy":
+		return r.setPolicy(value)
+	case "SignatureVersion":
+		if value != "" && value != "1" && value != "2" {
+			return ErrInvalidAttributeValue
+		}
+		r.SignatureVersion = value
+		return nil
+	case "DeliveryPolicy":
+		if value != "" {
+			if _, err := ParseTopicDeliveryPolicy(value); err != nil {
+				return err
+			}
+		}
+		r.DeliveryPolicy = value
+		return nil
+	}
+
+	return ErrInvalidAttributeName
+}
+
+// Attributes - returns the attributes of the subscription to the topic,
+// attributes like FilterPolicy are only returned when they are set.
+func (e Endpoint) Attributes(topic Resource) map[string]string {
+	attributes := map[string]string{
+		"SubscriptionArn":              topic.ARN() + ":" + e.Name,
+		"TopicArn":                     topic.ARN(),
+		"Owner":                        topic.AccountID,
+		"Protocol":                     e.Protocol,
+		"Endpoint":                     e.URI,
+		"PendingConfirmation":          strconv.FormatBool(e.PendingConfirmation),
+		"ConfirmationWasAuthenticated": "false",
+		"RawMessageDelivery":           strconv.FormatBool(e.RawMessageDelivery),
+	}
+
+	if e.Protocol == "http" || e.Protocol == "https" {
+		retryPolicy := e.RetryPolicy(topic)
+		data, _ := json.Marshal(SubscriptionDeliveryPolicy{HealthyRetryPolicy: &retryPolicy})
+		attributes["EffectiveDeliveryPolicy"] = string(data)
+	}
+	if e.DeliveryPolicy != "" {
+		attributes["DeliveryPolicy"] = e.DeliveryPolicy
+	}
+	if e.RedrivePolicy != "" {
+		attributes["RedrivePolicy"] = e.RedrivePolicy
+	}
+	if e.FilterPolicy != "" {
+		attributes["FilterPolicy"] = e.FilterPolicy
+		attributes["FilterPolicyScope"] = FilterPolicyScopeMessageAttributes
+		if e.FilterPolicyScope != "" {
+			attributes["FilterPolicyScope"] = e.FilterPolicyScope
+		}
+	}
+
+	return attributes
+}
+
+// SetAttribute - validates and sets the attribute of the subscription to the
+// topic, unknown attributes are rejected with ErrInvalidAttributeName. An
+// empty value removes the attribute.
+func (e *Endpoint) SetAttribute(topic Resource, name string, value string) error {
+	switch name {
+	case "DeliveryPolicy":
+		if e.Protocol != "http" && e.Protocol != "https" {
+			return ErrInvalidAttributeName
+		}
+		if value != "" {
+			if _, err := ParseSubscriptionDeliveryPolicy(value); err != nil {
+				return err
+			}
+		}
+		e.DeliveryPolicy = value
+		return nil
+	case "RedrivePolicy":
+		return e.setRedrivePolicy(topic, value)
+	case "RawMessageDelivery":
+		if value != "true" && value != "false" {
+			return ErrInvalidAttributeValue
+		}
+		e.RawMessageDelivery = value == "true"
+		return nil
+	case "FilterPolicy":
+		if value != "" {
+			if _, err := ParseFilterPolicy(value, e.FilterPolicyScope); err != nil {
+				return err
+			}
+		}
+		e.FilterPolicy = value
+		return nil
+	case "FilterPolicyScope":
+		if value == "" {
+			value = FilterPolicyScopeMessageAttributes
+		}
+		if value != FilterPolicyScopeMessageAttributes && value != FilterPolicyScopeMessageBody {
+			return ErrInvalidAttributeValue
+		}
+		// The filter policy must be valid in the new scope
+		if e.FilterPolicy != "" {
+			if _, err := ParseFilterPolicy(e.FilterPolicy, value); err != nil {
+				return err
+			}
+		}
+		e.FilterPolicyScope = value
+		return nil
+	}
+
+	return ErrInvalidAttributeName
+}
+
+// SetAttributes - sets the attributes of the subscription to the topic, and
+// returns the name of the attribute failing to be set. The scope of the filter
+// policy is set before the policy.
+func (e *Endpoint) SetAttributes(topic Resource, attributes map[string]string) (string, error) {
+	names := []string{}
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[j] == "FilterPolicyScope" {
+			return false
+		}
+		return names[i] == "FilterPolicyScope" || names[i] < names[j]
+	})
+
+	for _, name := range names {
+		if err := e.SetAttribute(topic, name, attributes[name]); err != nil {
+			return name, err
+		}
+	}
+
+	return "", nil
+}
+
+// setRedrivePolicy - sets the RedrivePolicy attribute, the dead-letter queue
+// must be an existing standard queue the topic can send messages to.
+func (e *Endpoint) setRedrivePolicy(topic Resource, value string) error {
+	if value == "" {
+		e.RedrivePolicy = ""
+		return nil
+	}
+
+	policy, err := ParseSubscriptionRedrivePolicy(value)
+	if err != nil {
+		return err
+	}
+
+	queue, ok := deadLetterQueue(*policy)
+	if !ok || queue.FifoQueue || !queue.AllowsSendFrom(topic) {
+		return ErrInvalidAttributeValue
+	}
+
+	e.RedrivePolicy = value
+	return nil
+}
+
+func deadLetterQueue(policy SubscriptionRedrivePolicy) (queue Resource, ok bool) {
+	target, err := ParseARN(policy.DeadLetterTargetArn)
+	if err != nil {
+		return queue, false
+	}
+
+	if db.Where(Resource{Service: SQS, AccountID: target.AccountID, Name: target.Name}).First(&queue).RecordNotFound() {
+		return queue, false
+	}
+
+	return queue, true
+}
@@ -0,0 +1,63 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/inwinstack/kaoliang/pkg/models"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTopicAttributes(t *testing.T) {
+	setup()
+
+	Convey("Given a topic with a confirmed and a pending subscription", t, func() {
+		topic := models.Resource{
+			Service:   models.SNS,
+			AccountID: "tester",
+			Name:      "foobar",
+			Endpoints: []models.Endpoint{
+				{Protocol: "sqs", URI: "arn:aws:sqs:us-east-1:tester:events"},
+				{Protocol: "http", URI: "http://example.com/", PendingConfirmation: true},
+			},
+		}
+		So(topic.SetTopicAttribute("DisplayName", "Events"), ShouldBeNil)
+
+		attributes := topic.TopicAttributes()
+
+		Convey("Subscriptions should be counted by their confirmation", func() {
+			So(attributes["SubscriptionsConfirmed"], ShouldEqual, "1")
+			So(attributes["SubscriptionsPending"], ShouldEqual, "1")
+		})
+
+		Convey("The owner and the display name should be returned", func() {
+			So(attributes["Owner"], ShouldEqual, "tester")
+			So(attributes["DisplayName"], ShouldEqual, "Events")
+			So(attributes["TopicArn"], ShouldEqual, "arn:aws:sns:us-east-1:tester:foobar")
+		})
+
+		Convey("Unset attributes should not be returned", func() {
+			_, ok := attributes["Policy"]
+			So(ok, ShouldBeFalse)
+		})
+	})
+
+	Convey("Given a subscription to a topic", t, func() {
+		topic := models.Resource{Service: models.SNS, AccountID: "tester", Name: "foobar"}
+		endpoint := models.Endpoint{Protocol: "sqs", URI: "arn:aws:sqs:us-east-1:tester:events", Name: "foo"}
+
+		Convey("RawMessageDelivery should only accept booleans", func() {
+			So(endpoint.SetAttribute(topic, "RawMessageDelivery", "yes"), ShouldEqual, models.ErrInvalidAttributeValue)
+			So(endpoint.SetAttribute(topic, "RawMessageDelivery", "true"), ShouldBeNil)
+			So(endpoint.Attributes(topic)["RawMessageDelivery"], ShouldEqual, "true")
+		})
+
+		Convey("Read-only attributes should not be set", func() {
+			So(endpoint.SetAttribute(topic, "Owner", "stranger"), ShouldEqual, models.ErrInvalidAttributeName)
+		})
+
+		Convey("The ARN of the subscription should be returned", func() {
+			So(endpoint.Attributes(topic)["SubscriptionArn"], ShouldEqual, "arn:aws:sns:us-east-1:tester:foobar:foo")
+		})
+	})
+}
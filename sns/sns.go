@@ -16,6 +16,7 @@ package main
 
 import (
 	"log"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/inwinstack/kaoliang/pkg/caches"
@@ -34,12 +35,37 @@ func init() {
 	config.SetServerConfig()
 	models.SetDB()
 	models.Migrate()
+	models.SetCache()
+	models.SetSigningKey()
 	caches.SetRedis()
 }
 
 func main() {
 	r := gin.Default()
 
+	// Endpoints confirm their subscriptions by visiting SubscribeURL, and
+	// remove them by visiting UnsubscribeURL
+	r.GET("/", func(c *gin.Context) {
+		action := c.Query("Action")
+		switch action {
+		case "ConfirmSubscription":
+			controllers.ConfirmSubscription(c)
+		case "Unsubscribe":
+			controllers.UnsubscribeByURL(c)
+		}
+	})
+
+	// Receivers verify the signatures of messages with the certificate
+	r.GET(models.SigningCertPath, func(c *gin.Context) {
+		certFile := config.GetServerConfig().SigningCertFile
+		if certFile == "" {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Header("Content-Type", "application/x-pem-file")
+		c.File(certFile)
+	})
+
 	r.POST("/", func(c *gin.Context) {
 		action := controllers.PostForm(c, "Action")
 		switch action {
@@ -51,10 +77,26 @@ func main() {
 			controllers.DeleteTopic(c)
 		case "Subscribe":
 			controllers.Subscribe(c)
+		case "ConfirmSubscription":
+			controllers.ConfirmSubscription(c)
 		case "ListSubscriptions":
 			controllers.ListSubscriptions(c)
+		case "ListSubscriptionsByTopic":
+			controllers.ListSubscriptionsByTopic(c)
 		case "Unsubscribe":
 			controllers.Unsubscribe(c)
+		case "GetTopicAttributes":
+			controllers.GetTopicAttributes(c)
+		case "SetTopicAttributes":
+			controllers.SetTopicAttributes(c)
+		case "GetSubscriptionAttributes":
+			controllers.GetSubscriptionAttributes(c)
+		case "SetSubscriptionAttributes":
+			controllers.SetSubscriptionAttributes(c)
+		case "Publish":
+			controllers.Publish(c)
+		case "PublishBatch":
+			controllers.PublishBatch(c)
 		}
 	})
 
@@ -35,6 +35,7 @@ func init() {
 	config.SetServerConfig()
 	models.SetDB()
 	models.Migrate()
+	models.SetCache()
 	caches.SetRedis()
 }
 
@@ -48,6 +49,26 @@ func main() {
 			controllers.DeleteQueue(c)
 		case "ReceiveMessage":
 			controllers.ReceiveMessage(c)
+		case "SendMessage":
+			controllers.SendMessage(c)
+		case "SendMessageBatch":
+			controllers.SendMessageBatch(c)
+		case "DeleteMessage":
+			controllers.DeleteMessage(c)
+		case "DeleteMessageBatch":
+			controllers.DeleteMessageBatch(c)
+		case "ChangeMessageVisibility":
+			controllers.ChangeMessageVisibility(c)
+		case "ChangeMessageVisibilityBatch":
+			controllers.ChangeMessageVisibilityBatch(c)
+		case "GetQueueAttributes":
+			controllers.GetQueueAttributes(c)
+		case "SetQueueAttributes":
+			controllers.SetQueueAttributes(c)
+		case "ListDeadLetterSourceQueues":
+			controllers.ListDeadLetterSourceQueues(c)
+		case "PurgeQueue":
+			controllers.PurgeQueue(c)
 		}
 	})
 
@@ -58,6 +79,10 @@ func main() {
 			controllers.ListQueues(c)
 		case "CreateQueue":
 			controllers.CreateQueue(c)
+		case "StartMessageMoveTask":
+			controllers.StartMessageMoveTask(c)
+		case "GetQueueUrl":
+			controllers.GetQueueURL(c)
 		}
 	})
 
@@ -68,10 +93,34 @@ func main() {
 			controllers.ListQueues(c)
 		case "CreateQueue":
 			controllers.CreateQueue(c)
+		case "StartMessageMoveTask":
+			controllers.StartMessageMoveTask(c)
+		case "GetQueueUrl":
+			controllers.GetQueueURL(c)
 		case "DeleteQueue":
 			controllers.DeleteQueue(c)
 		case "ReceiveMessage":
 			controllers.ReceiveMessage(c)
+		case "SendMessage":
+			controllers.SendMessage(c)
+		case "SendMessageBatch":
+			controllers.SendMessageBatch(c)
+		case "DeleteMessage":
+			controllers.DeleteMessage(c)
+		case "DeleteMessageBatch":
+			controllers.DeleteMessageBatch(c)
+		case "ChangeMessageVisibility":
+			controllers.ChangeMessageVisibility(c)
+		case "ChangeMessageVisibilityBatch":
+			controllers.ChangeMessageVisibilityBatch(c)
+		case "GetQueueAttributes":
+			controllers.GetQueueAttributes(c)
+		case "SetQueueAttributes":
+			controllers.SetQueueAttributes(c)
+		case "ListDeadLetterSourceQueues":
+			controllers.ListDeadLetterSourceQueues(c)
+		case "PurgeQueue":
+			controllers.PurgeQueue(c)
 		}
 	})
 